@@ -0,0 +1,301 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/nickemma/internal/logging"
+	"github.com/nickemma/internal/middleware"
+	"github.com/nickemma/internal/store"
+	"github.com/nickemma/internal/utils"
+	"github.com/nickemma/internal/validator"
+)
+
+type setUserDisabledRequest struct {
+	Disabled *bool `json:"disabled"`
+}
+
+type setUserRoleRequest struct {
+	Role string `json:"role"`
+}
+
+// AdminHandler the /admin routes. every action, reads included, goes to
+// the audit log
+type AdminHandler struct {
+	userStore    store.UserStore
+	tokenStore   store.TokenStore
+	workoutStore store.WorkoutStore
+	auditStore   store.AuditStore
+}
+
+func NewAdminHandler(userStore store.UserStore, tokenStore store.TokenStore, workoutStore store.WorkoutStore, auditStore store.AuditStore) *AdminHandler {
+	return &AdminHandler{
+		userStore:    userStore,
+		tokenStore:   tokenStore,
+		workoutStore: workoutStore,
+		auditStore:   auditStore,
+	}
+}
+
+// auditEntry the entry of an action of the current user
+func auditEntry(r *http.Request, action, targetType string, targetID int64, details map[string]any) *store.AuditEntry {
+	entry := &store.AuditEntry{
+		ActorID:    middleware.GetUser(r).ID,
+		Action:     action,
+		TargetType: targetType,
+		Details:    details,
+	}
+	if targetType != "" {
+		entry.TargetID = &targetID
+	}
+	return entry
+}
+
+// audit records a read of the current user. the read already happened when
+// this fails, so the entry is logged instead of lost. changes pass their
+// entry to the store, which writes it in the same transaction
+func (h *AdminHandler) audit(r *http.Request, action, targetType string, targetID int64, details map[string]any) {
+	err := h.auditStore.RecordAudit(r.Context(), auditEntry(r, action, targetType, targetID, details))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("recording audit entry",
+			"error", err,
+			"action", action,
+			"target_type", targetType,
+			"target_id", targetID,
+			"details", details,
+		)
+	}
+}
+
+// HandleListUsers searches the users by username or email, one page at a time
+func (h *AdminHandler) HandleListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := store.UserFilter{
+		Search: query.Get("search"),
+		Role:   query.Get("role"),
+		Cursor: query.Get("cursor"),
+	}
+
+	if filter.Role != "" && !slices.Contains(store.Roles, filter.Role) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "role must be one of " + strings.Join(store.Roles, ", ")})
+		return
+	}
+
+	if value := query.Get("disabled"); value != "" {
+		disabled, err := strconv.ParseBool(value)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "disabled must be true or false"})
+			return
+		}
+		filter.Disabled = &disabled
+	}
+
+	limit, err := utils.ReadIntQuery(r, "limit")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if limit != nil {
+		filter.Limit = *limit
+	}
+
+	users, nextCursor, err := h.userStore.ListUsers(r.Context(), filter)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	h.audit(r, store.AuditUsersList, "", 0, map[string]any{"query": r.URL.RawQuery, "results": len(users)})
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"users": users, "next_cursor": nextCursor})
+}
+
+// HandleGetUser any user by id
+func (h *AdminHandler) HandleGetUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.ReadJSON(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user id"})
+		return
+	}
+
+	user, err := h.userStore.GetUserByID(r.Context(), int(userID))
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	h.audit(r, store.AuditUserView, "user", userID, nil)
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+// HandleSetUserDisabled a disabled account can't log in and is logged out
+// everywhere, enabling it again doesn't bring the sessions back
+func (h *AdminHandler) HandleSetUserDisabled(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.ReadJSON(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user id"})
+		return
+	}
+
+	var req setUserDisabledRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Disabled == nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	if *req.Disabled && int(userID) == middleware.GetUser(r).ID {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "you can't disable your own account"})
+		return
+	}
+
+	action := store.AuditUserEnable
+	if *req.Disabled {
+		action = store.AuditUserDisable
+	}
+
+	user, err := h.userStore.SetDisabled(r.Context(), int(userID), *req.Disabled, auditEntry(r, action, "user", userID, nil))
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+// HandleSetUserRole admins can't change their own role, so there is always
+// an admin left to undo a mistake
+func (h *AdminHandler) HandleSetUserRole(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.ReadJSON(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user id"})
+		return
+	}
+
+	var req setUserRoleRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	v := validator.New()
+	v.Check(slices.Contains(store.Roles, req.Role), "role", "must be one of "+strings.Join(store.Roles, ", "))
+	if !v.Valid() {
+		utils.FailedValidation(w, v.Errors)
+		return
+	}
+
+	if int(userID) == middleware.GetUser(r).ID {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "you can't change your own role"})
+		return
+	}
+
+	user, err := h.userStore.SetRole(r.Context(), int(userID), req.Role, auditEntry(r, store.AuditUserSetRole, "user", userID, nil))
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+// HandleRevokeUserTokens logs the user out of every session
+func (h *AdminHandler) HandleRevokeUserTokens(w http.ResponseWriter, r *http.Request) {
+	userID, err := utils.ReadJSON(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid user id"})
+		return
+	}
+
+	user, err := h.userStore.GetUserByID(r.Context(), int(userID))
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	err = h.tokenStore.DeleteSessions(r.Context(), user.ID, auditEntry(r, store.AuditUserRevokeTokens, "user", userID, nil))
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+// HandleGetWorkout any workout, whoever owns it
+func (h *AdminHandler) HandleGetWorkout(w http.ResponseWriter, r *http.Request) {
+	workoutID, err := utils.ReadJSON(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
+		return
+	}
+
+	workout, err := h.workoutStore.GetAnyWorkoutByID(r.Context(), workoutID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	h.audit(r, store.AuditWorkoutView, "workout", workoutID, map[string]any{"owner_id": workout.UserID})
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": workout})
+}
+
+// HandleListAuditLog newest entries first, one page at a time
+func (h *AdminHandler) HandleListAuditLog(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := store.AuditFilter{
+		Action:     query.Get("action"),
+		TargetType: query.Get("target_type"),
+		Cursor:     query.Get("cursor"),
+	}
+
+	intFilters := map[string]*int{
+		"actor_id": &filter.ActorID,
+		"limit":    &filter.Limit,
+	}
+	for key, target := range intFilters {
+		value, err := utils.ReadIntQuery(r, key)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+			return
+		}
+		if value != nil {
+			*target = *value
+		}
+	}
+
+	targetID, err := utils.ReadIntQuery(r, "target_id")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if targetID != nil {
+		id := int64(*targetID)
+		filter.TargetID = &id
+	}
+
+	entries, nextCursor, err := h.auditStore.ListAudit(r.Context(), filter)
+	if errors.Is(err, store.ErrInvalidCursor) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	h.audit(r, store.AuditLogView, "", 0, map[string]any{"query": r.URL.RawQuery})
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"entries": entries, "next_cursor": nextCursor})
+}
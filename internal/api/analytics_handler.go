@@ -0,0 +1,115 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/nickemma/internal/logging"
+	"github.com/nickemma/internal/middleware"
+	"github.com/nickemma/internal/store"
+	"github.com/nickemma/internal/strength"
+	"github.com/nickemma/internal/utils"
+)
+
+type AnalyticsHandler struct {
+	analyticsStore store.AnalyticsStore
+}
+
+func NewAnalyticsHandler(analyticsStore store.AnalyticsStore) *AnalyticsHandler {
+	return &AnalyticsHandler{
+		analyticsStore: analyticsStore,
+	}
+}
+
+// HandleExerciseProgression best e1RM, volume and intensity of an exercise over time
+func (ah *AnalyticsHandler) HandleExerciseProgression(w http.ResponseWriter, r *http.Request) {
+	name, err := url.PathUnescape(chi.URLParam(r, "name"))
+	if err != nil || name == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid exercise name"})
+		return
+	}
+
+	formula, err := strength.ParseFormula(r.URL.Query().Get("formula"))
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	from, _, err := utils.ReadTimeQuery(r, "from")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	to, dateOnly, err := utils.ReadTimeQuery(r, "to")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if dateOnly {
+		end := to.AddDate(0, 0, 1)
+		to = &end
+	}
+
+	currentUser := middleware.GetUser(r)
+	filter := store.ProgressionFilter{
+		UserID:   currentUser.ID,
+		Exercise: name,
+		From:     from,
+		To:       to,
+		Bucket:   r.URL.Query().Get("bucket"),
+		Formula:  formula,
+	}
+
+	points, err := ah.analyticsStore.GetExerciseProgression(r.Context(), filter)
+	if errors.Is(err, store.ErrInvalidBucket) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("loading exercise progression", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"exercise":    name,
+		"formula":     formula,
+		"progression": points,
+	})
+}
+
+// HandleTrainingSummary the current week or month compared with the previous one,
+// date picks another period than the current one
+func (ah *AnalyticsHandler) HandleTrainingSummary(w http.ResponseWriter, r *http.Request) {
+	period := r.URL.Query().Get("period")
+	if period == "" {
+		period = "week"
+	}
+
+	at := time.Now()
+	date, _, err := utils.ReadTimeQuery(r, "date")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if date != nil {
+		at = *date
+	}
+
+	currentUser := middleware.GetUser(r)
+	summary, err := ah.analyticsStore.GetTrainingSummary(r.Context(), currentUser.ID, period, at)
+	if errors.Is(err, store.ErrInvalidPeriod) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("loading training summary", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"summary": summary})
+}
@@ -0,0 +1,274 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/nickemma/internal/logging"
+	"github.com/nickemma/internal/mailer"
+	"github.com/nickemma/internal/middleware"
+	"github.com/nickemma/internal/store"
+	"github.com/nickemma/internal/utils"
+	"github.com/nickemma/internal/validator"
+)
+
+// inviteAthleteRequest Athlete is a username or an email
+type inviteAthleteRequest struct {
+	Athlete string `json:"athlete"`
+}
+
+type acceptInviteRequest struct {
+	Token string `json:"token"`
+}
+
+// planWorkoutRequest the template of the plan with the day it is planned for
+type planWorkoutRequest struct {
+	store.WorkoutTemplate
+	ScheduledFor string `json:"scheduled_for"`
+	Notes        string `json:"notes"`
+}
+
+// CoachHandler the routes of both sides of a coaching relationship, /coach
+// for the coach and /users/me for the athlete
+type CoachHandler struct {
+	coachStore store.CoachStore
+	userStore  store.UserStore
+	mailer     mailer.Mailer
+}
+
+func NewCoachHandler(coachStore store.CoachStore, userStore store.UserStore, mailer mailer.Mailer) *CoachHandler {
+	return &CoachHandler{
+		coachStore: coachStore,
+		userStore:  userStore,
+		mailer:     mailer,
+	}
+}
+
+// HandleInviteAthlete mails a coach-invite token to the athlete, coaching
+// starts once they accept it
+func (h *CoachHandler) HandleInviteAthlete(w http.ResponseWriter, r *http.Request) {
+	var req inviteAthleteRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Athlete == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	// the answer is the same whether or not the athlete exists, so the
+	// endpoint can't be used to find out who has an account
+	accepted := utils.Envelope{"message": "if the user exists, the invitation was sent to them"}
+
+	var athlete *store.User
+	if strings.Contains(req.Athlete, "@") {
+		athlete, err = h.userStore.GetUserByEmail(r.Context(), req.Athlete)
+	} else {
+		athlete, err = h.userStore.GetUserByUsername(r.Context(), req.Athlete)
+	}
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	coach := middleware.GetUser(r)
+	if athlete.ID == coach.ID {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "you can't coach yourself"})
+		return
+	}
+
+	token, err := h.coachStore.CreateInvite(r.Context(), coach.ID, athlete.ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\n"+
+		"%s invited you to be coached by them. As your coach they can read your workouts,\n"+
+		"comment on them and plan workouts for you. Either of you can end it at any time.\n\n"+
+		"To accept, log in and send the token below to POST /users/me/coaches, it expires at %s.\n\n"+
+		"%s\n\n"+
+		"If you don't know %s you can ignore this email.\n",
+		athlete.Username, coach.Username, token.Expiry.UTC().Format("2006-01-02 15:04 MST"), token.Plaintext, coach.Username)
+
+	err = h.mailer.Send(athlete.Email, "You have been invited by a coach", body)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("sending coach invite email", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, accepted)
+}
+
+// HandleAcceptInvite the current user accepts the coach of the invite token
+func (h *CoachHandler) HandleAcceptInvite(w http.ResponseWriter, r *http.Request) {
+	var req acceptInviteRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Token == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	coachID, err := h.coachStore.AcceptInvite(r.Context(), middleware.GetUser(r).ID, req.Token)
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid or expired invite token"})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"coach_id": coachID})
+}
+
+// HandleListAthletes the athletes of the current user, pending invites included
+func (h *CoachHandler) HandleListAthletes(w http.ResponseWriter, r *http.Request) {
+	athletes, err := h.coachStore.ListAthletes(r.Context(), middleware.GetUser(r).ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"athletes": athletes})
+}
+
+// HandleListCoaches the coaches of the current user, pending invites included
+func (h *CoachHandler) HandleListCoaches(w http.ResponseWriter, r *http.Request) {
+	coaches, err := h.coachStore.ListCoaches(r.Context(), middleware.GetUser(r).ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"coaches": coaches})
+}
+
+// HandleRemoveAthlete the coach stops coaching the athlete or withdraws the invite
+func (h *CoachHandler) HandleRemoveAthlete(w http.ResponseWriter, r *http.Request) {
+	athleteID, err := utils.ReadJSON(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid athlete id"})
+		return
+	}
+
+	err = h.coachStore.RemoveCoachAthlete(r.Context(), middleware.GetUser(r).ID, int(athleteID))
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+// HandleRemoveCoach the athlete revokes the access of the coach
+func (h *CoachHandler) HandleRemoveCoach(w http.ResponseWriter, r *http.Request) {
+	coachID, err := utils.ReadJSON(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid coach id"})
+		return
+	}
+
+	err = h.coachStore.RemoveCoachAthlete(r.Context(), int(coachID), middleware.GetUser(r).ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+// HandlePlanWorkout puts a workout into the calendar of an athlete of the
+// current user, as a template the athlete can start
+func (h *CoachHandler) HandlePlanWorkout(w http.ResponseWriter, r *http.Request) {
+	athleteID, err := utils.ReadJSON(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid athlete id"})
+		return
+	}
+
+	var req planWorkoutRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("decoding request body", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	v := validator.New()
+	store.ValidateTemplate(v, &req.WorkoutTemplate)
+	scheduledFor, err := time.Parse(time.DateOnly, req.ScheduledFor)
+	v.Check(err == nil, "scheduled_for", "must be a YYYY-MM-DD date")
+	v.Check(len(req.Notes) <= 2000, "notes", "must not be more than 2000 bytes long")
+	if !v.Valid() {
+		utils.FailedValidation(w, v.Errors)
+		return
+	}
+
+	plan := &store.PlannedWorkout{
+		AthleteID:    int(athleteID),
+		ScheduledFor: scheduledFor,
+		Notes:        req.Notes,
+		Template:     &req.WorkoutTemplate,
+	}
+	err = h.coachStore.PlanWorkout(r.Context(), middleware.GetUser(r).ID, plan)
+	if errors.Is(err, store.ErrNotCoach) {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, store.ErrUnknownExercise) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"planned_workout": plan})
+}
+
+// HandleGetCalendar the planned workouts of the current user
+func (h *CoachHandler) HandleGetCalendar(w http.ResponseWriter, r *http.Request) {
+	h.calendar(w, r, middleware.GetUser(r).ID)
+}
+
+// HandleGetAthleteCalendar the planned workouts of an athlete of the current user
+func (h *CoachHandler) HandleGetAthleteCalendar(w http.ResponseWriter, r *http.Request) {
+	athleteID, err := utils.ReadJSON(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid athlete id"})
+		return
+	}
+	h.calendar(w, r, int(athleteID))
+}
+
+// calendar between the from and to dates of the query string, the store
+// returns nothing unless the current user is the athlete or their coach
+func (h *CoachHandler) calendar(w http.ResponseWriter, r *http.Request, athleteID int) {
+	from, _, err := utils.ReadTimeQuery(r, "from")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	to, _, err := utils.ReadTimeQuery(r, "to")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	plans, err := h.coachStore.ListPlannedWorkouts(r.Context(), athleteID, middleware.GetUser(r).ID, from, to)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"planned_workouts": plans})
+}
@@ -0,0 +1,187 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/nickemma/internal/logging"
+	"github.com/nickemma/internal/middleware"
+	"github.com/nickemma/internal/store"
+	"github.com/nickemma/internal/utils"
+)
+
+type ExerciseHandler struct {
+	exerciseStore store.ExerciseStore
+}
+
+type exerciseRequest struct {
+	Name             *string  `json:"name"`
+	Aliases          []string `json:"aliases"`
+	PrimaryMuscles   []string `json:"primary_muscles"`
+	SecondaryMuscles []string `json:"secondary_muscles"`
+	Equipment        *string  `json:"equipment"`
+	MovementPattern  *string  `json:"movement_pattern"`
+}
+
+func NewExerciseHandler(exerciseStore store.ExerciseStore) *ExerciseHandler {
+	return &ExerciseHandler{
+		exerciseStore: exerciseStore,
+	}
+}
+
+// HandleSearchExercises search the catalog and the user's own exercises
+func (eh *ExerciseHandler) HandleSearchExercises(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+	query := r.URL.Query()
+
+	limit, err := utils.ReadIntQuery(r, "limit")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+
+	filter := store.ExerciseFilter{
+		UserID:          currentUser.ID,
+		Query:           query.Get("q"),
+		Muscle:          query.Get("muscle"),
+		Equipment:       query.Get("equipment"),
+		MovementPattern: query.Get("movement_pattern"),
+	}
+	if limit != nil {
+		filter.Limit = *limit
+	}
+
+	exercises, err := eh.exerciseStore.SearchExercises(r.Context(), filter)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("searching exercises", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"exercises": exercises})
+}
+
+// HandleGetExerciseByID get an exercise from the catalog
+func (eh *ExerciseHandler) HandleGetExerciseByID(w http.ResponseWriter, r *http.Request) {
+	exerciseID, err := utils.ReadJSON(r)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("invalid id parameter", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid exercise id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	exercise, err := eh.exerciseStore.GetExerciseByID(r.Context(), exerciseID, currentUser.ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"exercise": exercise})
+}
+
+// HandleCreateExercise add a custom exercise for the current user
+func (eh *ExerciseHandler) HandleCreateExercise(w http.ResponseWriter, r *http.Request) {
+	var req exerciseRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("decoding request body", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request sent"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	exercise := &store.Exercise{UserID: &currentUser.ID}
+	applyExerciseRequest(exercise, &req)
+	if exercise.Name == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "name is required"})
+		return
+	}
+
+	createdExercise, err := eh.exerciseStore.CreateExercise(r.Context(), exercise)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"exercise": createdExercise})
+}
+
+// HandleUpdateExerciseByID update one of the user's custom exercises
+func (eh *ExerciseHandler) HandleUpdateExerciseByID(w http.ResponseWriter, r *http.Request) {
+	exerciseID, err := utils.ReadJSON(r)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("invalid id parameter", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid exercise id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	exercise, err := eh.exerciseStore.GetExerciseByID(r.Context(), exerciseID, currentUser.ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	var req exerciseRequest
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("decoding request body", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid bad request payload"})
+		return
+	}
+	applyExerciseRequest(exercise, &req)
+
+	err = eh.exerciseStore.UpdateExercise(r.Context(), exercise, currentUser.ID)
+	if errors.Is(err, store.ErrExerciseForbidden) {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "only your own exercises can be changed"})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"exercise": exercise})
+}
+
+// HandleDeleteExerciseByID delete one of the user's custom exercises
+func (eh *ExerciseHandler) HandleDeleteExerciseByID(w http.ResponseWriter, r *http.Request) {
+	exerciseID, err := utils.ReadJSON(r)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("invalid id parameter", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid exercise id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	err = eh.exerciseStore.DeleteExercise(r.Context(), exerciseID, currentUser.ID)
+	if errors.Is(err, store.ErrExerciseForbidden) {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "only your own exercises can be deleted"})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+func applyExerciseRequest(exercise *store.Exercise, req *exerciseRequest) {
+	if req.Name != nil {
+		exercise.Name = strings.TrimSpace(*req.Name)
+	}
+	if req.Aliases != nil {
+		exercise.Aliases = req.Aliases
+	}
+	if req.PrimaryMuscles != nil {
+		exercise.PrimaryMuscles = req.PrimaryMuscles
+	}
+	if req.SecondaryMuscles != nil {
+		exercise.SecondaryMuscles = req.SecondaryMuscles
+	}
+	if req.Equipment != nil {
+		exercise.Equipment = *req.Equipment
+	}
+	if req.MovementPattern != nil {
+		exercise.MovementPattern = *req.MovementPattern
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/nickemma/internal/logging"
+	"github.com/nickemma/internal/middleware"
+	"github.com/nickemma/internal/store"
+	"github.com/nickemma/internal/utils"
+)
+
+type RecordHandler struct {
+	recordStore store.RecordStore
+}
+
+func NewRecordHandler(recordStore store.RecordStore) *RecordHandler {
+	return &RecordHandler{
+		recordStore: recordStore,
+	}
+}
+
+// HandleGetMyRecords the current user's standing personal records
+func (rh *RecordHandler) HandleGetMyRecords(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+	records, err := rh.recordStore.GetCurrentRecords(r.Context(), currentUser.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("loading current records", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"records": records})
+}
+
+// HandleGetExerciseRecordHistory every record the current user set on an exercise
+func (rh *RecordHandler) HandleGetExerciseRecordHistory(w http.ResponseWriter, r *http.Request) {
+	exerciseID, err := utils.ReadJSON(r)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("invalid id parameter", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid exercise id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	records, err := rh.recordStore.GetRecordHistory(r.Context(), currentUser.ID, exerciseID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("loading record history", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"records": records})
+}
@@ -0,0 +1,307 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/nickemma/internal/logging"
+	"github.com/nickemma/internal/metrics"
+	"github.com/nickemma/internal/middleware"
+	"github.com/nickemma/internal/store"
+	"github.com/nickemma/internal/utils"
+	"github.com/nickemma/internal/validator"
+)
+
+type TemplateHandler struct {
+	templateStore store.TemplateStore
+	workoutStore  store.WorkoutStore
+	metrics       *metrics.Metrics
+}
+
+type templateRequest struct {
+	Title           *string              `json:"title"`
+	Description     *string              `json:"description"`
+	DurationMinutes *int                 `json:"duration_minutes"`
+	Entries         []store.WorkoutEntry `json:"entries"`
+}
+
+// startEntryRequest the actual numbers for a planned entry, matched on
+// order_index. sets replaces the planned sets, otherwise reps, weight and
+// duration_seconds are applied to every planned set, or to the entry itself
+// when it has no sets
+type startEntryRequest struct {
+	OrderIndex      int                `json:"order_index"`
+	Sets            []store.WorkoutSet `json:"sets"`
+	Reps            *int               `json:"reps"`
+	DurationSeconds *int               `json:"duration_seconds"`
+	Weight          *float64           `json:"weight"`
+	Notes           *string            `json:"notes"`
+}
+
+type startTemplateRequest struct {
+	Title           *string             `json:"title"`
+	Description     *string             `json:"description"`
+	DurationMinutes *int                `json:"duration_minutes"`
+	CaloriesBurned  *int                `json:"calories_burned"`
+	Entries         []startEntryRequest `json:"entries"`
+}
+
+// NewTemplateHandler metrics may be nil
+func NewTemplateHandler(templateStore store.TemplateStore, workoutStore store.WorkoutStore, metrics *metrics.Metrics) *TemplateHandler {
+	return &TemplateHandler{
+		templateStore: templateStore,
+		workoutStore:  workoutStore,
+		metrics:       metrics,
+	}
+}
+
+// getOwnedTemplate writes the error response itself and returns nil when the
+// template can't be used by the current user
+func (th *TemplateHandler) getOwnedTemplate(w http.ResponseWriter, r *http.Request) *store.WorkoutTemplate {
+	templateID, err := utils.ReadJSON(r)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("invalid id parameter", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid template id"})
+		return nil
+	}
+
+	currentUser := middleware.GetUser(r)
+	template, err := th.templateStore.GetTemplateByID(r.Context(), templateID, currentUser.ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return nil
+	}
+
+	return template
+}
+
+// HandleCreateTemplate save a workout structure as a template
+func (th *TemplateHandler) HandleCreateTemplate(w http.ResponseWriter, r *http.Request) {
+	var template store.WorkoutTemplate
+	err := json.NewDecoder(r.Body).Decode(&template)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("decoding request body", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request sent"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	template.UserID = currentUser.ID
+
+	v := validator.New()
+	if store.ValidateTemplate(v, &template); !v.Valid() {
+		utils.FailedValidation(w, v.Errors)
+		return
+	}
+
+	createdTemplate, err := th.templateStore.CreateTemplate(r.Context(), &template)
+	if errors.Is(err, store.ErrUnknownExercise) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"template": createdTemplate})
+}
+
+// HandleListTemplates list the current user's templates
+func (th *TemplateHandler) HandleListTemplates(w http.ResponseWriter, r *http.Request) {
+	currentUser := middleware.GetUser(r)
+	templates, err := th.templateStore.ListTemplates(r.Context(), currentUser.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("listing templates", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"templates": templates})
+}
+
+// HandleGetTemplateByID get a template
+func (th *TemplateHandler) HandleGetTemplateByID(w http.ResponseWriter, r *http.Request) {
+	template := th.getOwnedTemplate(w, r)
+	if template == nil {
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"template": template})
+}
+
+// HandleUpdateTemplateByID update a template, entries are replaced when sent
+func (th *TemplateHandler) HandleUpdateTemplateByID(w http.ResponseWriter, r *http.Request) {
+	template := th.getOwnedTemplate(w, r)
+	if template == nil {
+		return
+	}
+
+	var req templateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("decoding request body", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid bad request payload"})
+		return
+	}
+	if req.Title != nil {
+		template.Title = *req.Title
+	}
+	if req.Description != nil {
+		template.Description = *req.Description
+	}
+	if req.DurationMinutes != nil {
+		template.DurationMinutes = *req.DurationMinutes
+	}
+	if req.Entries != nil {
+		template.Entries = req.Entries
+	}
+
+	v := validator.New()
+	if store.ValidateTemplate(v, template); !v.Valid() {
+		utils.FailedValidation(w, v.Errors)
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	err = th.templateStore.UpdateTemplate(r.Context(), template, currentUser.ID)
+	if errors.Is(err, store.ErrUnknownExercise) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"template": template})
+}
+
+// HandleDeleteTemplateByID delete a template, workouts started from it are kept
+func (th *TemplateHandler) HandleDeleteTemplateByID(w http.ResponseWriter, r *http.Request) {
+	templateID, err := utils.ReadJSON(r)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("invalid id parameter", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid template id"})
+		return
+	}
+
+	currentUser := middleware.GetUser(r)
+	err = th.templateStore.DeleteTemplate(r.Context(), templateID, currentUser.ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+// HandleStartTemplate create a new workout from the template, the body is
+// optional and carries the actual numbers for the planned entries
+func (th *TemplateHandler) HandleStartTemplate(w http.ResponseWriter, r *http.Request) {
+	template := th.getOwnedTemplate(w, r)
+	if template == nil {
+		return
+	}
+
+	var req startTemplateRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		logging.FromContext(r.Context()).Warn("decoding request body", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request sent"})
+		return
+	}
+
+	workout := workoutFromTemplate(template, &req)
+	v := validator.New()
+	if store.ValidateWorkout(v, workout); !v.Valid() {
+		utils.FailedValidation(w, v.Errors)
+		return
+	}
+
+	createdWorkout, err := th.workoutStore.CreateWorkout(r.Context(), workout)
+	if errors.Is(err, store.ErrUnknownExercise) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	th.metrics.WorkoutCreated(len(createdWorkout.Entries))
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"workout": createdWorkout, "new_records": createdWorkout.NewRecords})
+}
+
+// workoutFromTemplate copies the planned entries into a new workout and
+// overwrites them with whatever the user actually did
+func workoutFromTemplate(template *store.WorkoutTemplate, req *startTemplateRequest) *store.Workout {
+	workout := &store.Workout{
+		UserID:          template.UserID,
+		Title:           template.Title,
+		Description:     template.Description,
+		DurationMinutes: template.DurationMinutes,
+		Entries:         make([]store.WorkoutEntry, len(template.Entries)),
+	}
+	if req.Title != nil {
+		workout.Title = *req.Title
+	}
+	if req.Description != nil {
+		workout.Description = *req.Description
+	}
+	if req.DurationMinutes != nil {
+		workout.DurationMinutes = *req.DurationMinutes
+	}
+	if req.CaloriesBurned != nil {
+		workout.CaloriesBurned = *req.CaloriesBurned
+	}
+
+	actuals := make(map[int]startEntryRequest, len(req.Entries))
+	for _, actual := range req.Entries {
+		actuals[actual.OrderIndex] = actual
+	}
+
+	for i, planned := range template.Entries {
+		entry := planned
+		entry.ID = 0
+		// copy so the template's own sets are never modified
+		entry.Sets = make([]store.WorkoutSet, len(planned.Sets))
+		for j, set := range planned.Sets {
+			set.ID = 0
+			entry.Sets[j] = set
+		}
+
+		if actual, ok := actuals[planned.OrderIndex]; ok {
+			if actual.Sets != nil {
+				entry.Sets = actual.Sets
+			}
+			for j := range entry.Sets {
+				if actual.Reps != nil {
+					entry.Sets[j].Reps = actual.Reps
+					entry.Sets[j].DurationSeconds = nil
+				}
+				if actual.DurationSeconds != nil {
+					entry.Sets[j].DurationSeconds = actual.DurationSeconds
+					entry.Sets[j].Reps = nil
+				}
+				if actual.Weight != nil {
+					entry.Sets[j].Weight = actual.Weight
+				}
+			}
+			// entries planned before per-set tracking have no sets
+			if len(entry.Sets) == 0 {
+				if actual.Reps != nil {
+					entry.Reps, entry.DurationSeconds = actual.Reps, nil
+				}
+				if actual.DurationSeconds != nil {
+					entry.DurationSeconds, entry.Reps = actual.DurationSeconds, nil
+				}
+				if actual.Weight != nil {
+					entry.Weight = actual.Weight
+				}
+			}
+			if actual.Notes != nil {
+				entry.Notes = *actual.Notes
+			}
+		}
+		workout.Entries[i] = entry
+	}
+
+	return workout
+}
@@ -2,19 +2,31 @@ package api
 
 import (
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 	"time"
 
+	"github.com/nickemma/internal/logging"
+	"github.com/nickemma/internal/mailer"
+	"github.com/nickemma/internal/metrics"
+	"github.com/nickemma/internal/middleware"
+	"github.com/nickemma/internal/ratelimit"
 	"github.com/nickemma/internal/store"
 	"github.com/nickemma/internal/tokens"
+	"github.com/nickemma/internal/totp"
 	"github.com/nickemma/internal/utils"
 )
 
 type TokenHandler struct {
-	tokenStore store.TokenStore
-	userStore  store.UserStore
-	logger     *log.Logger
+	tokenStore     store.TokenStore
+	userStore      store.UserStore
+	twoFactorStore store.TwoFactorStore
+	mailer         mailer.Mailer
+	metrics        *metrics.Metrics
+	// usernameLimiter throttles logins per username, whatever IP they come from
+	usernameLimiter *ratelimit.Limiter
 }
 
 type createTokenRequest struct {
@@ -22,11 +34,30 @@ type createTokenRequest struct {
 	Password string `json:"password"`
 }
 
-func NewTokenHandler(tokenStore store.TokenStore, userStore store.UserStore, logger *log.Logger) *TokenHandler {
+// twoFactorTokenRequest either a TOTP code or a recovery code
+type twoFactorTokenRequest struct {
+	TwoFactorToken string `json:"two_factor_token"`
+	Code           string `json:"code"`
+	RecoveryCode   string `json:"recovery_code"`
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+type passwordResetTokenRequest struct {
+	Email string `json:"email"`
+}
+
+// NewTokenHandler metrics and usernameLimiter may be nil
+func NewTokenHandler(tokenStore store.TokenStore, userStore store.UserStore, twoFactorStore store.TwoFactorStore, mailer mailer.Mailer, metrics *metrics.Metrics, usernameLimiter *ratelimit.Limiter) *TokenHandler {
 	return &TokenHandler{
-		tokenStore: tokenStore,
-		userStore:  userStore,
-		logger:     logger,
+		tokenStore:      tokenStore,
+		userStore:       userStore,
+		twoFactorStore:  twoFactorStore,
+		mailer:          mailer,
+		metrics:         metrics,
+		usernameLimiter: usernameLimiter,
 	}
 }
 
@@ -35,39 +66,315 @@ func (h *TokenHandler) HandleCreateToken(w http.ResponseWriter, r *http.Request)
 	err := json.NewDecoder(r.Body).Decode(&req)
 
 	if err != nil {
-		h.logger.Printf("ERROR: createTokenRequest: %v", err)
+		logging.FromContext(r.Context()).Warn("decoding request body", "error", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
 		return
 	}
 
-	// lets get the user
-	user, err := h.userStore.GetUserByUsername(req.Username)
-	if err != nil || user == nil {
-		h.logger.Printf("ERROR: GetUserByUsername: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+	allowed, retryAfter, err := h.usernameLimiter.Allow(r.Context(), strings.ToLower(req.Username))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("rate limiter", "limiter", "login-username", "error", err)
+	} else if !allowed {
+		utils.RateLimitExceeded(w, retryAfter)
+		return
+	}
+
+	// unknown usernames and locked accounts get the answer of a wrong
+	// password, after a bcrypt comparison of their own, so neither the
+	// response nor its timing tells which accounts exist
+	user, err := h.userStore.GetUserByUsername(r.Context(), req.Username)
+	if errors.Is(err, store.ErrNotFound) {
+		store.CompareDummyPassword(req.Password)
+		h.metrics.FailedLogin()
+		utils.InvalidCredentials(w)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
 		return
 	}
 
 	passwordsDoMatch, err := user.PasswordHash.Matches(req.Password)
 	if err != nil {
-		h.logger.Printf("ERORR: PasswordHash.Mathes %v", err)
+		logging.FromContext(r.Context()).Error("checking password", "error", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
 
+	// even the right password doesn't get in until the lock expires
+	if user.IsLocked(time.Now()) {
+		h.metrics.FailedLogin()
+		utils.InvalidCredentials(w)
+		return
+	}
+
 	if !passwordsDoMatch {
-		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid credentials"})
+		h.metrics.FailedLogin()
+		lockedUntil, err := h.userStore.RecordFailedLogin(r.Context(), user.ID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("recording failed login", "error", err)
+		} else if lockedUntil != nil && lockedUntil.After(time.Now()) {
+			logging.FromContext(r.Context()).Warn("account locked after failed logins", "user_id", user.ID, "locked_until", lockedUntil)
+		}
+		utils.InvalidCredentials(w)
 		return
 	}
 
-	token, err := h.tokenStore.CreateNewToken(user.ID, 24*time.Hour, tokens.ScopeAuth)
+	// checked after the password, so it doesn't tell strangers which accounts exist
+	if user.IsDisabled() {
+		utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "your account has been disabled"})
+		return
+	}
+
+	// with 2FA the password only gets a short-lived token that is exchanged
+	// at POST /tokens/2fa. the failed logins are kept until then, or knowing
+	// the password would reset the lockout between guessed codes
+	if user.TwoFactorEnabled {
+		token, err := h.tokenStore.CreateNewToken(r.Context(), user.ID, tokens.TwoFactorPendingTokenTTL, tokens.ScopeTwoFactorPending)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("creating token", "error", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+		h.metrics.TokensIssued(token.Scope)
+
+		utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"two_factor_required": true, "two_factor_token": token})
+		return
+	}
+
+	h.completeLogin(w, r, user)
+}
+
+// completeLogin the token pair of a login that passed every check
+func (h *TokenHandler) completeLogin(w http.ResponseWriter, r *http.Request, user *store.User) {
+	if user.FailedLoginAttempts > 0 {
+		err := h.userStore.ResetFailedLogins(r.Context(), user.ID)
+		if err != nil {
+			utils.WriteError(w, r, err)
+			return
+		}
+	}
+
+	// the user agent is remembered so the user can recognize the session later on
+	accessToken, refreshToken, err := h.tokenStore.CreateTokenPair(r.Context(), user.ID, r.UserAgent())
 	if err != nil {
-		h.logger.Printf("ERORR: Creating Token %v", err)
+		logging.FromContext(r.Context()).Error("creating token pair", "error", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
+	}
+	h.metrics.TokensIssued(accessToken.Scope, refreshToken.Scope)
 
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"auth_token": accessToken, "refresh_token": refreshToken})
+}
+
+// HandleCreateTwoFactorToken trades the 2fa-pending token of a login and a
+// TOTP or recovery code for the token pair. wrong codes count as failed
+// logins, so the lockout also limits guessing
+func (h *TokenHandler) HandleCreateTwoFactorToken(w http.ResponseWriter, r *http.Request) {
+	var req twoFactorTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.TwoFactorToken == "" || (req.Code == "") == (req.RecoveryCode == "") {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload, send the two_factor_token with either a code or a recovery_code"})
+		return
 	}
 
-	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"auth_token": token})
+	user, err := h.userStore.GetUserToken(r.Context(), tokens.ScopeTwoFactorPending, req.TwoFactorToken)
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired two-factor token"})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	if now := time.Now(); user.IsLocked(now) {
+		h.metrics.FailedLogin()
+		utils.RateLimitExceeded(w, user.LockedUntil.Sub(now))
+		return
+	}
+
+	valid, err := h.checkSecondFactor(r, user, req)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	if !valid {
+		h.metrics.FailedLogin()
+		lockedUntil, err := h.userStore.RecordFailedLogin(r.Context(), user.ID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("recording failed login", "error", err)
+		} else if lockedUntil != nil && lockedUntil.After(time.Now()) {
+			logging.FromContext(r.Context()).Warn("account locked after failed two-factor codes", "user_id", user.ID, "locked_until", lockedUntil)
+		}
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid two-factor code"})
+		return
+	}
+
+	// the pending token is single use, like the codes
+	err = h.tokenStore.DeleteToken(r.Context(), tokens.ScopeTwoFactorPending, req.TwoFactorToken)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	h.completeLogin(w, r, user)
+}
+
+// checkSecondFactor a TOTP code is only accepted once, a recovery code is
+// spent when it matches
+func (h *TokenHandler) checkSecondFactor(r *http.Request, user *store.User, req twoFactorTokenRequest) (bool, error) {
+	if req.RecoveryCode != "" {
+		err := h.twoFactorStore.UseRecoveryCode(r.Context(), user.ID, req.RecoveryCode)
+		if errors.Is(err, store.ErrNotFound) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		logging.FromContext(r.Context()).Info("recovery code used", "user_id", user.ID)
+		return true, nil
+	}
+
+	twoFactor, err := h.twoFactorStore.GetTwoFactor(r.Context(), user.ID)
+	if err != nil {
+		return false, err
+	}
+
+	step, ok, err := totp.Validate(twoFactor.Secret, req.Code, time.Now())
+	if err != nil || !ok {
+		return false, err
+	}
+
+	err = h.twoFactorStore.UseTOTPStep(r.Context(), user.ID, step)
+	if errors.Is(err, store.ErrTOTPCodeUsed) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// HandleRefreshToken trades a refresh token for a new access token, the
+// refresh token is rotated and can't be used again
+func (h *TokenHandler) HandleRefreshToken(w http.ResponseWriter, r *http.Request) {
+	var req refreshTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.RefreshToken == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	accessToken, refreshToken, err := h.tokenStore.RotateRefreshToken(r.Context(), req.RefreshToken, r.UserAgent())
+	if errors.Is(err, store.ErrRefreshTokenReused) {
+		logging.FromContext(r.Context()).Warn("refresh token reuse, token family revoked")
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired refresh token"})
+		return
+	}
+	if errors.Is(err, store.ErrInvalidRefreshToken) {
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid or expired refresh token"})
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("rotating refresh token", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	h.metrics.TokensIssued(accessToken.Scope, refreshToken.Scope)
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"auth_token": accessToken, "refresh_token": refreshToken})
+}
+
+// HandleCreatePasswordResetToken mails a password reset token. the response
+// is the same whether or not the email is known, so it can't be used to find accounts
+func (h *TokenHandler) HandleCreatePasswordResetToken(w http.ResponseWriter, r *http.Request) {
+	var req passwordResetTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Email == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	accepted := utils.Envelope{"message": "if the email belongs to an account, password reset instructions have been sent to it"}
+
+	user, err := h.userStore.GetUserByEmail(r.Context(), req.Email)
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	// only the latest reset token is valid
+	err = h.tokenStore.DeleteAllTokensForUser(r.Context(), user.ID, tokens.ScopePasswordReset)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("deleting tokens", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	token, err := h.tokenStore.CreateNewToken(r.Context(), user.ID, tokens.PasswordResetTokenTTL, tokens.ScopePasswordReset)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("creating token", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	h.metrics.TokensIssued(token.Scope)
+
+	body := fmt.Sprintf("Hi %s,\n\n"+
+		"Use the token below to reset your password, it expires at %s.\n\n"+
+		"%s\n\n"+
+		"Send it with your new password to PUT /users/password.\n"+
+		"If you didn't ask for a password reset you can ignore this email.\n",
+		user.Username, token.Expiry.UTC().Format("2006-01-02 15:04 MST"), token.Plaintext)
+
+	err = h.mailer.Send(user.Email, "Reset your password", body)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("sending password reset email", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, accepted)
+}
+
+// HandleRevokeToken logs out the current session by revoking its bearer token
+func (h *TokenHandler) HandleRevokeToken(w http.ResponseWriter, r *http.Request) {
+	err := h.tokenStore.DeleteToken(r.Context(), tokens.ScopeAuth, middleware.GetToken(r))
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+// HandleRevokeAllTokens logs out every session of the current user, this one included
+func (h *TokenHandler) HandleRevokeAllTokens(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	err := h.tokenStore.DeleteSessions(r.Context(), user.ID, nil)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("deleting tokens", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+// HandleListSessions the active sessions of the current user
+func (h *TokenHandler) HandleListSessions(w http.ResponseWriter, r *http.Request) {
+	user := middleware.GetUser(r)
+	sessions, err := h.tokenStore.ListSessions(r.Context(), user.ID, middleware.GetToken(r))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("listing sessions", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
 
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"sessions": sessions})
 }
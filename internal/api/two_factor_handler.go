@@ -0,0 +1,241 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/nickemma/internal/logging"
+	"github.com/nickemma/internal/middleware"
+	"github.com/nickemma/internal/store"
+	"github.com/nickemma/internal/totp"
+	"github.com/nickemma/internal/utils"
+)
+
+type setupTwoFactorRequest struct {
+	Password string `json:"password"`
+}
+
+type verifyTwoFactorRequest struct {
+	Code string `json:"code"`
+}
+
+// confirmTwoFactorRequest what it takes to weaken or reset 2FA: the
+// password and a code from the authenticator
+type confirmTwoFactorRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+type TwoFactorHandler struct {
+	twoFactorStore store.TwoFactorStore
+	userStore      store.UserStore
+	// issuer the name authenticator apps show next to the account
+	issuer string
+}
+
+func NewTwoFactorHandler(twoFactorStore store.TwoFactorStore, userStore store.UserStore, issuer string) *TwoFactorHandler {
+	return &TwoFactorHandler{
+		twoFactorStore: twoFactorStore,
+		userStore:      userStore,
+		issuer:         issuer,
+	}
+}
+
+// HandleSetupTwoFactor generates a new TOTP secret for the current user.
+// 2FA stays off until a code from it is sent to HandleVerifyTwoFactor
+func (h *TwoFactorHandler) HandleSetupTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req setupTwoFactorRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Password == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	if !checkCurrentPassword(w, r, h.userStore, req.Password) {
+		return
+	}
+
+	user := middleware.GetUser(r)
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		logging.FromContext(r.Context()).Error("generating totp secret", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	err = h.twoFactorStore.SetTOTPSecret(r.Context(), user.ID, secret)
+	if errors.Is(err, store.ErrTwoFactorEnabled) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(h.issuer, user.Username, secret),
+	})
+}
+
+// HandleVerifyTwoFactor enables 2FA with a code from the secret of the
+// setup. the recovery codes are only ever shown in this response
+func (h *TwoFactorHandler) HandleVerifyTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var req verifyTwoFactorRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Code == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	twoFactor, err := h.twoFactorStore.GetTwoFactor(r.Context(), user.ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	if twoFactor.Enabled {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": store.ErrTwoFactorEnabled.Error()})
+		return
+	}
+	if twoFactor.Secret == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "two-factor setup has not been started, see POST /users/me/2fa/setup"})
+		return
+	}
+
+	step, ok, err := totp.Validate(twoFactor.Secret, req.Code, time.Now())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("validating totp code", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+	if !ok {
+		utils.FailedValidation(w, map[string]string{"code": "is invalid or expired"})
+		return
+	}
+
+	recoveryCodes, err := totp.GenerateRecoveryCodes()
+	if err != nil {
+		logging.FromContext(r.Context()).Error("generating recovery codes", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	err = h.twoFactorStore.EnableTwoFactor(r.Context(), user.ID, step, recoveryCodes)
+	if errors.Is(err, store.ErrTwoFactorEnabled) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"message":        "two-factor authentication is enabled, keep the recovery codes somewhere safe",
+		"recovery_codes": recoveryCodes,
+	})
+}
+
+// confirmTwoFactor reads a confirmTwoFactorRequest and checks the password
+// and the code, the code can't be used again. writes the response and
+// returns false when anything is off
+func (h *TwoFactorHandler) confirmTwoFactor(w http.ResponseWriter, r *http.Request) bool {
+	var req confirmTwoFactorRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Password == "" || req.Code == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload, send the password and a code"})
+		return false
+	}
+
+	if !checkCurrentPassword(w, r, h.userStore, req.Password) {
+		return false
+	}
+
+	user := middleware.GetUser(r)
+	twoFactor, err := h.twoFactorStore.GetTwoFactor(r.Context(), user.ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return false
+	}
+	if !twoFactor.Enabled {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": store.ErrTwoFactorNotEnabled.Error()})
+		return false
+	}
+
+	step, ok, err := totp.Validate(twoFactor.Secret, req.Code, time.Now())
+	if err != nil {
+		logging.FromContext(r.Context()).Error("validating totp code", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return false
+	}
+	if ok {
+		err = h.twoFactorStore.UseTOTPStep(r.Context(), user.ID, step)
+		if errors.Is(err, store.ErrTOTPCodeUsed) {
+			ok = false
+		} else if err != nil {
+			utils.WriteError(w, r, err)
+			return false
+		}
+	}
+	if !ok {
+		utils.FailedValidation(w, map[string]string{"code": "is invalid or expired"})
+		return false
+	}
+
+	return true
+}
+
+// HandleDisableTwoFactor turns 2FA off for the current user
+func (h *TwoFactorHandler) HandleDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !h.confirmTwoFactor(w, r) {
+		return
+	}
+
+	user := middleware.GetUser(r)
+	err := h.twoFactorStore.DisableTwoFactor(r.Context(), user.ID)
+	if errors.Is(err, store.ErrTwoFactorNotEnabled) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	logging.FromContext(r.Context()).Info("two-factor authentication disabled", "user_id", user.ID)
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "two-factor authentication is disabled"})
+}
+
+// HandleRegenerateRecoveryCodes replaces the recovery codes of the current
+// user, the new ones are only ever shown in this response
+func (h *TwoFactorHandler) HandleRegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	if !h.confirmTwoFactor(w, r) {
+		return
+	}
+
+	recoveryCodes, err := totp.GenerateRecoveryCodes()
+	if err != nil {
+		logging.FromContext(r.Context()).Error("generating recovery codes", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	err = h.twoFactorStore.ReplaceRecoveryCodes(r.Context(), middleware.GetUser(r).ID, recoveryCodes)
+	if errors.Is(err, store.ErrTwoFactorNotEnabled) {
+		utils.WriteJSON(w, http.StatusConflict, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{
+		"message":        "the old recovery codes no longer work, keep the new ones somewhere safe",
+		"recovery_codes": recoveryCodes,
+	})
+}
@@ -1,14 +1,20 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
 	"net/http"
-	"regexp"
+	"time"
 
+	"github.com/nickemma/internal/logging"
+	"github.com/nickemma/internal/mailer"
+	"github.com/nickemma/internal/middleware"
 	"github.com/nickemma/internal/store"
+	"github.com/nickemma/internal/tokens"
 	"github.com/nickemma/internal/utils"
+	"github.com/nickemma/internal/validator"
 )
 
 type registerUserRequest struct {
@@ -18,42 +24,54 @@ type registerUserRequest struct {
 	Bio      string `json:"bio"`
 }
 
-type UserHandler struct {
-	userStore store.UserStore
-	logger    *log.Logger
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
 }
 
-func NewUserHandler(userStore store.UserStore, logger *log.Logger) *UserHandler {
-	return &UserHandler{
-		userStore: userStore,
-		logger:    logger,
-	}
+type activateUserRequest struct {
+	Token string `json:"token"`
 }
 
-func (h *UserHandler) validateRegisterRequest(req *registerUserRequest) error {
-	if req.Username == "" {
-		return errors.New("username is required")
-	}
+type activationTokenRequest struct {
+	Email string `json:"email"`
+}
 
-	if len(req.Username) > 50 {
-		return errors.New("username cannot be greater than 50 characters")
-	}
+// updateProfileRequest only the fields that are present are changed
+type updateProfileRequest struct {
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+	Bio      *string `json:"bio"`
+}
 
-	if req.Email == "" {
-		return errors.New("email is required")
-	}
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
 
-	emailRegex := regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
-	if !emailRegex.MatchString(req.Email) {
-		return errors.New("invalid email format")
-	}
+type deleteUserRequest struct {
+	Password string `json:"password"`
+}
 
-	// we can have password validatoin aswell
-	if req.Password == "" {
-		return errors.New("password is required")
+type UserHandler struct {
+	userStore  store.UserStore
+	tokenStore store.TokenStore
+	mailer     mailer.Mailer
+}
+
+func NewUserHandler(userStore store.UserStore, tokenStore store.TokenStore, mailer mailer.Mailer) *UserHandler {
+	return &UserHandler{
+		userStore:  userStore,
+		tokenStore: tokenStore,
+		mailer:     mailer,
 	}
+}
 
-	return nil
+// validateRegisterRequest collects every invalid field of the request
+func validateRegisterRequest(v *validator.Validator, req *registerUserRequest) {
+	store.ValidateUsername(v, req.Username)
+	store.ValidateEmail(v, req.Email)
+	store.ValidatePasswordPlaintext(v, "password", req.Password)
 }
 
 func (h *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request) {
@@ -61,14 +79,14 @@ func (h *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request)
 
 	err := json.NewDecoder(r.Body).Decode(&req)
 	if err != nil {
-		h.logger.Printf("ERROR: decoding register request: %v", err)
+		logging.FromContext(r.Context()).Warn("decoding request body", "error", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
 		return
 	}
 
-	err = h.validateRegisterRequest(&req)
-	if err != nil {
-		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+	v := validator.New()
+	if validateRegisterRequest(v, &req); !v.Valid() {
+		utils.FailedValidation(w, v.Errors)
 		return
 	}
 
@@ -84,18 +102,351 @@ func (h *UserHandler) HandleRegisterUser(w http.ResponseWriter, r *http.Request)
 	// how do we deal with their passwords
 	err = user.PasswordHash.Set(req.Password)
 	if err != nil {
-		h.logger.Printf("ERROR: hashing password %v", err)
+		logging.FromContext(r.Context()).Error("hashing password", "error", err)
 		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
 		return
 	}
 
-	err = h.userStore.CreateUser(user)
+	err = h.userStore.CreateUser(r.Context(), user)
 	if err != nil {
-		h.logger.Printf("ERROR: registering user %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		utils.WriteError(w, r, err)
 		return
 	}
 
+	// the account exists either way, a failed email is not a failed signup
+	err = h.sendActivationEmail(r.Context(), user)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("sending activation email", "error", err)
+	}
+
 	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"user": user})
 
 }
+
+// sendActivationEmail mails a fresh activation token, older ones stop working
+func (h *UserHandler) sendActivationEmail(ctx context.Context, user *store.User) error {
+	err := h.tokenStore.DeleteAllTokensForUser(ctx, user.ID, tokens.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	token, err := h.tokenStore.CreateNewToken(ctx, user.ID, tokens.ActivationTokenTTL, tokens.ScopeActivation)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf("Hi %s,\n\n"+
+		"Use the token below to activate your account, it expires at %s.\n\n"+
+		"%s\n\n"+
+		"Send it to PUT /users/activated.\n",
+		user.Username, token.Expiry.UTC().Format("2006-01-02 15:04 MST"), token.Plaintext)
+
+	return h.mailer.Send(user.Email, "Activate your account", body)
+}
+
+// HandleResetPassword sets a new password with a password reset token, every
+// session of the user is logged out afterwards
+func (h *UserHandler) HandleResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req resetPasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	v := validator.New()
+	v.Check(req.Token != "", "token", "must be provided")
+	store.ValidatePasswordPlaintext(v, "password", req.Password)
+	if !v.Valid() {
+		utils.FailedValidation(w, v.Errors)
+		return
+	}
+
+	user, err := h.userStore.GetUserToken(r.Context(), tokens.ScopePasswordReset, req.Token)
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid or expired password reset token"})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	err = user.PasswordHash.Set(req.Password)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("hashing password", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	err = h.userStore.UpdatePassword(r.Context(), user)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("updating password", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	for _, scope := range []string{tokens.ScopePasswordReset, tokens.ScopeAuth, tokens.ScopeRefresh, tokens.ScopeTwoFactorPending} {
+		err = h.tokenStore.DeleteAllTokensForUser(r.Context(), user.ID, scope)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("deleting tokens", "error", err)
+			utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+			return
+		}
+	}
+
+	// the reset proved the email is theirs, a lockout from guessed passwords no longer applies
+	err = h.userStore.ResetFailedLogins(r.Context(), user.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("resetting failed logins", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "your password was reset"})
+}
+
+// HandleActivateUser verifies the user's email with an activation token
+func (h *UserHandler) HandleActivateUser(w http.ResponseWriter, r *http.Request) {
+	var req activateUserRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Token == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	user, err := h.userStore.GetUserToken(r.Context(), tokens.ScopeActivation, req.Token)
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid or expired activation token"})
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	user.Activated = true
+	err = h.userStore.UpdateUser(r.Context(), user)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("updating user", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	err = h.tokenStore.DeleteAllTokensForUser(r.Context(), user.ID, tokens.ScopeActivation)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("deleting tokens", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+// HandleCreateActivationToken mails a new activation token when the signup
+// email got lost or expired. the answer is the same whether or not the email
+// belongs to an account waiting for activation
+func (h *UserHandler) HandleCreateActivationToken(w http.ResponseWriter, r *http.Request) {
+	var req activationTokenRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil || req.Email == "" {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	accepted := utils.Envelope{"message": "if the email belongs to an account waiting for activation, activation instructions have been sent to it"}
+
+	user, err := h.userStore.GetUserByEmail(r.Context(), req.Email)
+	if errors.Is(err, store.ErrNotFound) {
+		utils.WriteJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	if user.Activated {
+		utils.WriteJSON(w, http.StatusAccepted, accepted)
+		return
+	}
+
+	err = h.sendActivationEmail(r.Context(), user)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("sending activation email", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusAccepted, accepted)
+}
+
+// HandleGetMe the profile of the current user
+func (h *UserHandler) HandleGetMe(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": middleware.GetUser(r)})
+}
+
+// HandleUpdateMe changing the email deactivates the account until the new
+// address is verified
+func (h *UserHandler) HandleUpdateMe(w http.ResponseWriter, r *http.Request) {
+	var req updateProfileRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		logging.FromContext(r.Context()).Warn("decoding request body", "error", err)
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	v := validator.New()
+	if req.Username != nil {
+		store.ValidateUsername(v, *req.Username)
+	}
+	if req.Email != nil {
+		store.ValidateEmail(v, *req.Email)
+	}
+	if !v.Valid() {
+		utils.FailedValidation(w, v.Errors)
+		return
+	}
+
+	user := middleware.GetUser(r)
+	emailChanged := false
+
+	if req.Username != nil {
+		user.Username = *req.Username
+	}
+
+	if req.Email != nil {
+		if *req.Email != user.Email {
+			emailChanged = true
+			user.Email = *req.Email
+			user.Activated = false
+		}
+	}
+
+	if req.Bio != nil {
+		user.Bio = *req.Bio
+	}
+
+	err = h.userStore.UpdateUser(r.Context(), user)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	if emailChanged {
+		err = h.sendActivationEmail(r.Context(), user)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("sending activation email", "error", err)
+		}
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"user": user})
+}
+
+// checkCurrentPassword writes the response and returns false unless the
+// password is the current user's. wrong passwords count as failed logins, so
+// a stolen session can't be used to guess the password past the lockout
+func checkCurrentPassword(w http.ResponseWriter, r *http.Request, userStore store.UserStore, password string) bool {
+	user := middleware.GetUser(r)
+	if now := time.Now(); user.IsLocked(now) {
+		utils.RateLimitExceeded(w, user.LockedUntil.Sub(now))
+		return false
+	}
+
+	passwordsDoMatch, err := user.PasswordHash.Matches(password)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("checking password", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return false
+	}
+	if !passwordsDoMatch {
+		lockedUntil, err := userStore.RecordFailedLogin(r.Context(), user.ID)
+		if err != nil {
+			logging.FromContext(r.Context()).Error("recording failed login", "error", err)
+		} else if lockedUntil != nil && lockedUntil.After(time.Now()) {
+			logging.FromContext(r.Context()).Warn("account locked after failed password checks", "user_id", user.ID, "locked_until", lockedUntil)
+		}
+		utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "password is incorrect"})
+		return false
+	}
+	return true
+}
+
+// HandleChangePassword the current password has to be given again
+func (h *UserHandler) HandleChangePassword(w http.ResponseWriter, r *http.Request) {
+	var req changePasswordRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	v := validator.New()
+	store.ValidatePasswordPlaintext(v, "new_password", req.NewPassword)
+	if !v.Valid() {
+		utils.FailedValidation(w, v.Errors)
+		return
+	}
+
+	user := middleware.GetUser(r)
+	if !checkCurrentPassword(w, r, h.userStore, req.CurrentPassword) {
+		return
+	}
+
+	err = user.PasswordHash.Set(req.NewPassword)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("hashing password", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	err = h.userStore.UpdatePassword(r.Context(), user)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("updating password", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	// a pending reset link must not undo the change
+	err = h.tokenStore.DeleteAllTokensForUser(r.Context(), user.ID, tokens.ScopePasswordReset)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("deleting tokens", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	// a stolen session must not outlive the old password, only this one stays
+	err = h.tokenStore.DeleteOtherSessions(r.Context(), user.ID, middleware.GetToken(r))
+	if err != nil {
+		logging.FromContext(r.Context()).Error("deleting tokens", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"message": "your password was changed, every other session was logged out"})
+}
+
+// HandleDeleteMe deletes the account and everything in it, the password
+// has to be given again
+func (h *UserHandler) HandleDeleteMe(w http.ResponseWriter, r *http.Request) {
+	var req deleteUserRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+
+	user := middleware.GetUser(r)
+	if !checkCurrentPassword(w, r, h.userStore, req.Password) {
+		return
+	}
+
+	err = h.userStore.DeleteUser(r.Context(), user.ID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("deleting user", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
@@ -1,24 +1,30 @@
 package api
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/nickemma/internal/logging"
+	"github.com/nickemma/internal/metrics"
+	"github.com/nickemma/internal/middleware"
 	"github.com/nickemma/internal/store"
 	"github.com/nickemma/internal/utils"
-	"log"
+	"github.com/nickemma/internal/validator"
 	"net/http"
+	"strconv"
 )
 
 // decoupling our database
 type WorkoutHandler struct {
 	workoutStore store.WorkoutStore
-	logger       *log.Logger
+	metrics      *metrics.Metrics
 }
 
-func NewWorkoutHandler(workoutStore store.WorkoutStore, logger *log.Logger) *WorkoutHandler {
+// NewWorkoutHandler metrics may be nil
+func NewWorkoutHandler(workoutStore store.WorkoutStore, metrics *metrics.Metrics) *WorkoutHandler {
 	return &WorkoutHandler{
 		workoutStore: workoutStore,
-		logger:       logger,
+		metrics:      metrics,
 	}
 }
 
@@ -26,14 +32,14 @@ func NewWorkoutHandler(workoutStore store.WorkoutStore, logger *log.Logger) *Wor
 func (wh *WorkoutHandler) HandlerGetWorkoutByID(w http.ResponseWriter, r *http.Request) {
 	workoutId, err := utils.ReadJSON(r)
 	if err != nil {
-		wh.logger.Printf("ERROR: readIdParams: %v", err)
+		logging.FromContext(r.Context()).Warn("invalid id parameter", "error", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
 		return
 	}
-	workout, err := wh.workoutStore.GetWorkoutByID1(workoutId)
+	currentUser := middleware.GetUser(r)
+	workout, err := wh.workoutStore.GetWorkoutByID1(r.Context(), workoutId, currentUser.ID)
 	if err != nil {
-		wh.logger.Printf("ERROR: GetworkoutById: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		utils.WriteError(w, r, err)
 		return
 	}
 	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": workout})
@@ -45,37 +51,47 @@ func (wh *WorkoutHandler) HandlerCreateWorkout(w http.ResponseWriter, r *http.Re
 	err := json.NewDecoder(r.Body).Decode(&workout)
 
 	if err != nil {
-		wh.logger.Printf("ERROR: decodingworkout: %v", err)
+		logging.FromContext(r.Context()).Warn("decoding request body", "error", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request sent"})
 		return
 	}
 
-	createdWorkout, err := wh.workoutStore.CreateWorkout(&workout)
+	// the owner always comes from the authenticated user, never the payload
+	currentUser := middleware.GetUser(r)
+	workout.UserID = currentUser.ID
+
+	v := validator.New()
+	if store.ValidateWorkout(v, &workout); !v.Valid() {
+		utils.FailedValidation(w, v.Errors)
+		return
+	}
+
+	createdWorkout, err := wh.workoutStore.CreateWorkout(r.Context(), &workout)
+	if errors.Is(err, store.ErrUnknownExercise) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		wh.logger.Printf("ERROR: CreateWorkout: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		utils.WriteError(w, r, err)
 		return
 	}
-	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": createdWorkout})
+	wh.metrics.WorkoutCreated(len(createdWorkout.Entries))
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": createdWorkout, "new_records": createdWorkout.NewRecords})
 }
 
 // HandleUpdateWorkoutById update a workout
 func (wh *WorkoutHandler) HandleUpdateWorkoutById(w http.ResponseWriter, r *http.Request) {
 	workoutId, err := utils.ReadJSON(r)
 	if err != nil {
-		wh.logger.Printf("ERROR: readIdParams: %v", err)
+		logging.FromContext(r.Context()).Warn("invalid id parameter", "error", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request sent"})
 		return
 	}
 
-	existingWorkout, err := wh.workoutStore.GetWorkoutByID(workoutId)
+	currentUser := middleware.GetUser(r)
+	existingWorkout, err := wh.workoutStore.GetWorkoutByID(r.Context(), workoutId, currentUser.ID)
 	if err != nil {
-		wh.logger.Printf("ERROR: GetWorkoutByID: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
-		return
-	}
-	if existingWorkout == nil {
-		http.NotFound(w, r)
+		utils.WriteError(w, r, err)
 		return
 	}
 	// at this point we have our workout
@@ -88,7 +104,7 @@ func (wh *WorkoutHandler) HandleUpdateWorkoutById(w http.ResponseWriter, r *http
 	}
 	err = json.NewDecoder(r.Body).Decode(&updateWorkoutRequest)
 	if err != nil {
-		wh.logger.Printf("ERROR: decodingerror: %v", err)
+		logging.FromContext(r.Context()).Warn("decoding request body", "error", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid bad request payload"})
 		return
 	}
@@ -107,34 +123,181 @@ func (wh *WorkoutHandler) HandleUpdateWorkoutById(w http.ResponseWriter, r *http
 	if updateWorkoutRequest.Entries != nil {
 		existingWorkout.Entries = updateWorkoutRequest.Entries
 	}
-	err = wh.workoutStore.UpdateWorkout(existingWorkout)
+
+	v := validator.New()
+	if store.ValidateWorkout(v, existingWorkout); !v.Valid() {
+		utils.FailedValidation(w, v.Errors)
+		return
+	}
+
+	err = wh.workoutStore.UpdateWorkout(r.Context(), existingWorkout, currentUser.ID)
+	if errors.Is(err, store.ErrUnknownExercise) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
 	if err != nil {
-		wh.logger.Printf("ERROR: updateworkout: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "error updating workout"})
+		utils.WriteError(w, r, err)
 		return
 	}
-	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": existingWorkout})
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workout": existingWorkout, "new_records": existingWorkout.NewRecords})
 }
 
 // // HandleDeleteWorkoutById update a workout
 func (wh *WorkoutHandler) HandleDeleteWorkoutById(w http.ResponseWriter, r *http.Request) {
 	workoutId, err := utils.ReadJSON(r)
 	if err != nil {
-		wh.logger.Printf("ERROR: readIdParams: %v", err)
+		logging.FromContext(r.Context()).Warn("invalid id parameter", "error", err)
 		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
 		return
 	}
 
-	err = wh.workoutStore.DeleteWorkout(workoutId)
-	if err == sql.ErrNoRows {
-		wh.logger.Printf("ERROR: deleteworkout: %v", err)
-		utils.WriteJSON(w, http.StatusNotFound, utils.Envelope{"error": "workout not found"})
+	currentUser := middleware.GetUser(r)
+	err = wh.workoutStore.DeleteWorkout(r.Context(), workoutId, currentUser.ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+}
+
+// HandleListWorkouts list the current user's workouts, one page at a time
+func (wh *WorkoutHandler) HandleListWorkouts(w http.ResponseWriter, r *http.Request) {
+	wh.listWorkouts(w, r, middleware.GetUser(r).ID)
+}
+
+// HandleListAthleteWorkouts the workouts of an athlete of the current
+// user, the store returns none unless the athlete accepted them as coach
+func (wh *WorkoutHandler) HandleListAthleteWorkouts(w http.ResponseWriter, r *http.Request) {
+	athleteID, err := utils.ReadJSON(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid athlete id"})
+		return
+	}
+	wh.listWorkouts(w, r, int(athleteID))
+}
+
+// listWorkouts the workouts of ownerID as seen by the current user, with
+// the filters of the query string
+func (wh *WorkoutHandler) listWorkouts(w http.ResponseWriter, r *http.Request, ownerID int) {
+	query := r.URL.Query()
+
+	filter := store.WorkoutFilter{
+		UserID:   ownerID,
+		ViewerID: middleware.GetUser(r).ID,
+		Title:    query.Get("title"),
+		Sort:     query.Get("sort"),
+		Cursor:   query.Get("cursor"),
+	}
+
+	var err error
+	var dateOnly bool
+	filter.From, _, err = utils.ReadTimeQuery(r, "from")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
 		return
 	}
+	filter.To, dateOnly, err = utils.ReadTimeQuery(r, "to")
 	if err != nil {
-		wh.logger.Printf("ERROR: deleteworkout: %v", err)
-		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "error deleting workout"})
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
 		return
 	}
-	utils.WriteJSON(w, http.StatusNoContent, utils.Envelope{})
+	if dateOnly {
+		// to=2025-01-31 should include everything logged on the 31st
+		end := filter.To.AddDate(0, 0, 1)
+		filter.To = &end
+	}
+
+	intFilters := map[string]**int{
+		"min_duration": &filter.MinDuration,
+		"max_duration": &filter.MaxDuration,
+		"min_calories": &filter.MinCalories,
+		"max_calories": &filter.MaxCalories,
+	}
+	for key, target := range intFilters {
+		*target, err = utils.ReadIntQuery(r, key)
+		if err != nil {
+			utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+			return
+		}
+	}
+
+	limit, err := utils.ReadIntQuery(r, "limit")
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if limit != nil {
+		filter.Limit = *limit
+	}
+
+	workouts, nextCursor, err := wh.workoutStore.ListWorkouts(r.Context(), filter)
+	if errors.Is(err, store.ErrInvalidCursor) || errors.Is(err, store.ErrInvalidSort) {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		logging.FromContext(r.Context()).Error("listing workouts", "error", err)
+		utils.WriteJSON(w, http.StatusInternalServerError, utils.Envelope{"error": "internal server error"})
+		return
+	}
+
+	var next *string
+	if nextCursor != "" {
+		next = &nextCursor
+	}
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"workouts": workouts, "next_cursor": next})
+}
+
+// HandleAddEntryComment comments on an entry of the workout, as its owner or
+// as one of their coaches
+func (wh *WorkoutHandler) HandleAddEntryComment(w http.ResponseWriter, r *http.Request) {
+	workoutId, err := utils.ReadJSON(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
+		return
+	}
+	entryID, err := strconv.Atoi(chi.URLParam(r, "entryID"))
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid entry id"})
+		return
+	}
+
+	var comment store.EntryComment
+	err = json.NewDecoder(r.Body).Decode(&comment)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid request payload"})
+		return
+	}
+	comment.WorkoutEntryID = entryID
+
+	v := validator.New()
+	if store.ValidateEntryComment(v, &comment); !v.Valid() {
+		utils.FailedValidation(w, v.Errors)
+		return
+	}
+
+	err = wh.workoutStore.AddEntryComment(r.Context(), workoutId, &comment, middleware.GetUser(r).ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusCreated, utils.Envelope{"comment": comment})
+}
+
+// HandleListWorkoutComments the comments on the entries of the workout
+func (wh *WorkoutHandler) HandleListWorkoutComments(w http.ResponseWriter, r *http.Request) {
+	workoutId, err := utils.ReadJSON(r)
+	if err != nil {
+		utils.WriteJSON(w, http.StatusBadRequest, utils.Envelope{"error": "invalid workout id"})
+		return
+	}
+
+	comments, err := wh.workoutStore.ListWorkoutComments(r.Context(), workoutId, middleware.GetUser(r).ID)
+	if err != nil {
+		utils.WriteError(w, r, err)
+		return
+	}
+
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"comments": comments})
 }
@@ -1,64 +1,225 @@
 package app
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"github.com/nickemma/internal/api"
+	"github.com/nickemma/internal/config"
+	"github.com/nickemma/internal/logging"
+	"github.com/nickemma/internal/mailer"
+	"github.com/nickemma/internal/metrics"
 	"github.com/nickemma/internal/middleware"
+	"github.com/nickemma/internal/ratelimit"
 	"github.com/nickemma/internal/store"
+	"github.com/nickemma/internal/utils"
 	"github.com/nickemma/migration"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"time"
 )
 
 type Application struct {
-	Logger         *log.Logger
-	WorkoutHandler *api.WorkoutHandler
-	UserHandler    *api.UserHandler
-	TokenHandler   *api.TokenHandler
-	Middleware     middleware.UserMiddleware
-	DB             *sql.DB
+	Config           *config.Config
+	Logger           *slog.Logger
+	WorkoutHandler   *api.WorkoutHandler
+	TemplateHandler  *api.TemplateHandler
+	ExerciseHandler  *api.ExerciseHandler
+	RecordHandler    *api.RecordHandler
+	AnalyticsHandler *api.AnalyticsHandler
+	UserHandler      *api.UserHandler
+	TokenHandler     *api.TokenHandler
+	TwoFactorHandler *api.TwoFactorHandler
+	AdminHandler     *api.AdminHandler
+	CoachHandler     *api.CoachHandler
+	Middleware       middleware.UserMiddleware
+	Metrics          *metrics.Metrics // nil when metrics are disabled
+	LoginLimiter     *ratelimit.Limiter
+	DB               *sql.DB
 }
 
-func NewApplication() (*Application, error) {
+// readinessTimeout bounds the database checks of the readiness probe
+const readinessTimeout = 2 * time.Second
+
+func NewApplication(cfg *config.Config) (*Application, error) {
+	logger, err := logging.New(os.Stdout, cfg.LogFormat, cfg.LogLevel)
+	if err != nil {
+		return nil, err
+	}
+	// the standard log package, used by goose, goes through it too
+	slog.SetDefault(logger)
+
 	// database connections
-	pgDB, err := store.Open()
+	pgDB, err := store.Open(cfg.DB.ConnString())
 	if err != nil {
 		return nil, err
 	}
+	pgDB.SetMaxOpenConns(cfg.DB.MaxOpenConns)
+	pgDB.SetMaxIdleConns(cfg.DB.MaxIdleConns)
+	pgDB.SetConnMaxIdleTime(cfg.DB.ConnMaxIdleTime)
 	// migrations run and check
 	err = store.MigrateFs(pgDB, migration.FS, ".")
 	if err != nil {
 		panic(err)
 	}
 
-	logger := log.New(os.Stdout, "INFO: ", log.Ldate|log.Ltime)
+	mailSender := newMailer(cfg.Mail)
+	store.BcryptCost = cfg.Auth.BcryptCost
 
 	// Store goes here
 	workoutStore := store.NewPostgresWorkoutStore(pgDB)
 	userStore := store.NewPostgresUserStore(pgDB)
 	tokenStore := store.NewPostgresTokenStore(pgDB)
+	templateStore := store.NewPostgresTemplateStore(pgDB)
+	exerciseStore := store.NewPostgresExerciseStore(pgDB)
+	recordStore := store.NewPostgresRecordStore(pgDB)
+	analyticsStore := store.NewPostgresAnalyticsStore(pgDB)
+	twoFactorStore := store.NewPostgresTwoFactorStore(pgDB)
+	auditStore := store.NewPostgresAuditStore(pgDB)
+	coachStore := store.NewPostgresCoachStore(pgDB)
+
+	// per store deadlines, analytics gets its own so a slow report can't
+	// hold on to the connections the rest of the api needs
+	workoutStore.SetQueryTimeout(cfg.DB.QueryTimeout)
+	userStore.SetQueryTimeout(cfg.DB.QueryTimeout)
+	tokenStore.SetQueryTimeout(cfg.DB.QueryTimeout)
+	templateStore.SetQueryTimeout(cfg.DB.QueryTimeout)
+	exerciseStore.SetQueryTimeout(cfg.DB.QueryTimeout)
+	recordStore.SetQueryTimeout(cfg.DB.QueryTimeout)
+	twoFactorStore.SetQueryTimeout(cfg.DB.QueryTimeout)
+	auditStore.SetQueryTimeout(cfg.DB.QueryTimeout)
+	coachStore.SetQueryTimeout(cfg.DB.QueryTimeout)
+	analyticsStore.SetQueryTimeout(cfg.DB.AnalyticsQueryTimeout)
+	tokenStore.SetTokenTTLs(cfg.Auth.AccessTokenTTL, cfg.Auth.RefreshTokenTTL)
+	userStore.SetLockoutPolicy(store.LockoutPolicy{
+		Threshold:    cfg.Auth.LockoutThreshold,
+		BaseDuration: cfg.Auth.LockoutDuration,
+		MaxDuration:  cfg.Auth.LockoutMaxDuration,
+	})
+
+	var limitBackend ratelimit.Backend = ratelimit.NewMemoryBackend()
+	if cfg.Auth.RateLimitBackend == "postgres" {
+		rateLimitStore := store.NewPostgresRateLimitStore(pgDB)
+		rateLimitStore.SetQueryTimeout(cfg.DB.QueryTimeout)
+		limitBackend = rateLimitStore
+	}
+	loginLimiter := ratelimit.New(limitBackend, "login-ip", cfg.Auth.LoginIPLimit)
+	usernameLimiter := ratelimit.New(limitBackend, "login-username", cfg.Auth.LoginUsernameLimit)
+
+	var appMetrics *metrics.Metrics
+	if cfg.Metrics.Enabled {
+		appMetrics = metrics.New(pgDB)
+	}
 
 	// Handlers goes here
-	workoutHandler := api.NewWorkoutHandler(workoutStore, logger)
-	userHandler := api.NewUserHandler(userStore, logger)
-	tokenHandler := api.NewTokenHandler(tokenStore, userStore, logger)
-	middlewareHandler := middleware.UserMiddleware{userStore: userStore}
+	workoutHandler := api.NewWorkoutHandler(workoutStore, appMetrics)
+	templateHandler := api.NewTemplateHandler(templateStore, workoutStore, appMetrics)
+	exerciseHandler := api.NewExerciseHandler(exerciseStore)
+	recordHandler := api.NewRecordHandler(recordStore)
+	analyticsHandler := api.NewAnalyticsHandler(analyticsStore)
+	userHandler := api.NewUserHandler(userStore, tokenStore, mailSender)
+	tokenHandler := api.NewTokenHandler(tokenStore, userStore, twoFactorStore, mailSender, appMetrics, usernameLimiter)
+	twoFactorHandler := api.NewTwoFactorHandler(twoFactorStore, userStore, cfg.Auth.TOTPIssuer)
+	adminHandler := api.NewAdminHandler(userStore, tokenStore, workoutStore, auditStore)
+	coachHandler := api.NewCoachHandler(coachStore, userStore, mailSender)
+	middlewareHandler := middleware.UserMiddleware{UserStore: userStore}
 
 	app := &Application{
-		Logger:         logger,
-		WorkoutHandler: workoutHandler,
-		UserHandler:    userHandler,
-		TokenHandler:   tokenHandler,
-		Middleware:     middlewareHandler,
-		DB:             pgDB,
+		Config:           cfg,
+		Logger:           logger,
+		WorkoutHandler:   workoutHandler,
+		TemplateHandler:  templateHandler,
+		ExerciseHandler:  exerciseHandler,
+		RecordHandler:    recordHandler,
+		AnalyticsHandler: analyticsHandler,
+		UserHandler:      userHandler,
+		TokenHandler:     tokenHandler,
+		TwoFactorHandler: twoFactorHandler,
+		AdminHandler:     adminHandler,
+		CoachHandler:     coachHandler,
+		Middleware:       middlewareHandler,
+		Metrics:          appMetrics,
+		LoginLimiter:     loginLimiter,
+		DB:               pgDB,
 	}
 
 	return app, nil
 }
 
+// newMailer sends through SMTP when a host is configured, otherwise emails
+// are written to the mail directory for local development
+func newMailer(cfg config.Mail) mailer.Mailer {
+	if cfg.SMTPHost == "" {
+		return mailer.NewFileMailer(cfg.Dir, cfg.Sender)
+	}
+	return mailer.NewSMTPMailer(cfg.SMTPHost, cfg.SMTPPort, cfg.SMTPUsername, cfg.SMTPPassword, cfg.Sender)
+}
+
 func (app *Application) HealthCheck(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "Status is available and ok\n")
 
 }
+
+// HealthLive the process is up and serving, nothing else is checked so a
+// database outage doesn't get the container restarted
+func (app *Application) HealthLive(w http.ResponseWriter, r *http.Request) {
+	utils.WriteJSON(w, http.StatusOK, utils.Envelope{"status": "available"})
+}
+
+// HealthReady answers 503 until Postgres is reachable and migrated to the
+// newest embedded migration, the pool stats are included either way. failed
+// checks only say "unavailable", the errors are logged
+func (app *Application) HealthReady(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := utils.Envelope{}
+	ready := true
+
+	err := app.DB.PingContext(ctx)
+	if err != nil {
+		// the probe is public and driver errors can name the host and
+		// credentials, the details only go to the log
+		logging.FromContext(r.Context()).Error("readiness check: pinging database", "error", err)
+		ready = false
+		checks["database"] = "unavailable"
+	} else {
+		checks["database"] = "ok"
+	}
+
+	current, latest, err := store.SchemaVersion(ctx, app.DB, migration.FS)
+	switch {
+	case err != nil:
+		logging.FromContext(r.Context()).Error("readiness check: reading schema version", "error", err)
+		ready = false
+		checks["migrations"] = "unavailable"
+	case current != latest:
+		ready = false
+		checks["migrations"] = fmt.Sprintf("database is at version %d, expected %d", current, latest)
+	default:
+		checks["migrations"] = "ok"
+	}
+
+	stats := app.DB.Stats()
+	pool := utils.Envelope{
+		"max_open_connections": stats.MaxOpenConnections,
+		"open_connections":     stats.OpenConnections,
+		"in_use":               stats.InUse,
+		"idle":                 stats.Idle,
+		"wait_count":           stats.WaitCount,
+		"wait_duration_ms":     stats.WaitDuration.Milliseconds(),
+	}
+
+	status, code := "ready", http.StatusOK
+	if !ready {
+		status, code = "unavailable", http.StatusServiceUnavailable
+	}
+	utils.WriteJSON(w, code, utils.Envelope{
+		"status":          status,
+		"checks":          checks,
+		"schema_version":  current,
+		"latest_version":  latest,
+		"connection_pool": pool,
+	})
+}
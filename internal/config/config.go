@@ -0,0 +1,339 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gopkg.in/yaml.v3"
+)
+
+// Config everything the server reads at startup. values are layered, lowest
+// first: defaults, the config file, environment variables, command-line flags
+type Config struct {
+	Addr      string
+	LogLevel  string
+	LogFormat string
+
+	DB      DB
+	Server  Server
+	Auth    Auth
+	Mail    Mail
+	Metrics Metrics
+	Tracing Tracing
+}
+
+// DB when DSN is set it wins over the separate connection fields
+type DB struct {
+	DSN      string
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Name     string
+	SSLMode  string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxIdleTime time.Duration
+
+	QueryTimeout          time.Duration
+	AnalyticsQueryTimeout time.Duration
+}
+
+type Server struct {
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+	IdleTimeout  time.Duration
+	// ShutdownTimeout how long in-flight requests get to finish on SIGINT/SIGTERM
+	ShutdownTimeout time.Duration
+}
+
+type Auth struct {
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	BcryptCost      int
+
+	// LoginIPLimit and LoginUsernameLimit are logins per minute, 0 turns them off
+	RateLimitBackend   string
+	LoginIPLimit       int
+	LoginUsernameLimit int
+
+	// LockoutThreshold failed logins in a row lock the account, 0 turns it off
+	LockoutThreshold   int
+	LockoutDuration    time.Duration
+	LockoutMaxDuration time.Duration
+
+	// TOTPIssuer the name authenticator apps show for the account
+	TOTPIssuer string
+}
+
+// Mail SMTP is used when SMTPHost is set, otherwise emails are written to Dir
+type Mail struct {
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+	Sender       string
+	Dir          string
+}
+
+// Metrics /metrics is served by the API listener unless Addr is set
+type Metrics struct {
+	Enabled bool
+	Addr    string
+}
+
+// Tracing Exporter is none, stdout, otlp or memory (tests only). Endpoint is
+// the OTLP/HTTP collector URL, the OTEL_EXPORTER_OTLP_* variables apply when empty
+type Tracing struct {
+	Exporter    string
+	Endpoint    string
+	SampleRatio float64
+}
+
+var (
+	rateLimitBackends = []string{"memory", "postgres"}
+	traceExporters    = []string{"none", "stdout", "otlp", "memory"}
+	logLevels         = []string{"debug", "info", "warn", "error"}
+	logFormats        = []string{"text", "json"}
+)
+
+// ConnString the DSN when given, otherwise one built from the DB fields
+func (db DB) ConnString() string {
+	if db.DSN != "" {
+		return db.DSN
+	}
+	return fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%d sslmode=%s",
+		db.Host, db.User, db.Password, db.Name, db.Port, db.SSLMode)
+}
+
+// newFlagSet binds every setting to a flag, the environment variable of a
+// flag is its name upper-cased with dashes turned into underscores,
+// -db-host is DB_HOST
+func newFlagSet(cfg *Config) *flag.FlagSet {
+	fs := flag.NewFlagSet("thrivetrack", flag.ContinueOnError)
+
+	fs.StringVar(&cfg.Addr, "addr", ":8080", "HTTP listen address")
+	fs.StringVar(&cfg.LogLevel, "log-level", "info", "log level (debug, info, warn or error)")
+	fs.StringVar(&cfg.LogFormat, "log-format", "text", "log format (text or json)")
+
+	fs.StringVar(&cfg.DB.DSN, "db-dsn", "", "PostgreSQL DSN, overrides the other db-* connection flags")
+	fs.StringVar(&cfg.DB.Host, "db-host", "localhost", "PostgreSQL host")
+	fs.IntVar(&cfg.DB.Port, "db-port", 5432, "PostgreSQL port")
+	fs.StringVar(&cfg.DB.User, "db-user", "root", "PostgreSQL user")
+	fs.StringVar(&cfg.DB.Password, "db-password", "postgres", "PostgreSQL password")
+	fs.StringVar(&cfg.DB.Name, "db-name", "postgres", "PostgreSQL database name")
+	fs.StringVar(&cfg.DB.SSLMode, "db-sslmode", "disable", "PostgreSQL sslmode")
+	fs.IntVar(&cfg.DB.MaxOpenConns, "db-max-open-conns", 25, "maximum open database connections")
+	fs.IntVar(&cfg.DB.MaxIdleConns, "db-max-idle-conns", 25, "maximum idle database connections")
+	fs.DurationVar(&cfg.DB.ConnMaxIdleTime, "db-conn-max-idle-time", 15*time.Minute, "how long a connection may stay idle")
+	fs.DurationVar(&cfg.DB.QueryTimeout, "db-query-timeout", 5*time.Second, "deadline of a store call, 0 disables it")
+	fs.DurationVar(&cfg.DB.AnalyticsQueryTimeout, "analytics-query-timeout", 15*time.Second, "deadline of an analytics store call, 0 disables it")
+
+	fs.DurationVar(&cfg.Server.ReadTimeout, "read-timeout", 10*time.Second, "HTTP read timeout")
+	fs.DurationVar(&cfg.Server.WriteTimeout, "write-timeout", 30*time.Second, "HTTP write timeout")
+	fs.DurationVar(&cfg.Server.IdleTimeout, "idle-timeout", time.Minute, "HTTP idle timeout")
+	fs.DurationVar(&cfg.Server.ShutdownTimeout, "shutdown-timeout", 30*time.Second, "how long in-flight requests get to finish on shutdown")
+
+	fs.DurationVar(&cfg.Auth.AccessTokenTTL, "access-token-ttl", time.Hour, "lifetime of access tokens")
+	fs.DurationVar(&cfg.Auth.RefreshTokenTTL, "refresh-token-ttl", 30*24*time.Hour, "lifetime of refresh tokens")
+	fs.IntVar(&cfg.Auth.BcryptCost, "bcrypt-cost", 12, "bcrypt cost of new password hashes")
+	fs.StringVar(&cfg.Auth.RateLimitBackend, "rate-limit-backend", "memory", "where rate limits are counted (memory or postgres, shared by every instance)")
+	fs.IntVar(&cfg.Auth.LoginIPLimit, "login-ip-limit", 20, "logins per minute and client IP, 0 disables the limit")
+	fs.IntVar(&cfg.Auth.LoginUsernameLimit, "login-username-limit", 10, "logins per minute and username, 0 disables the limit")
+	fs.IntVar(&cfg.Auth.LockoutThreshold, "lockout-threshold", 5, "failed logins in a row before the account is locked, 0 disables the lockout")
+	fs.DurationVar(&cfg.Auth.LockoutDuration, "lockout-duration", time.Minute, "first account lock, doubled with every further failed login")
+	fs.DurationVar(&cfg.Auth.LockoutMaxDuration, "lockout-max-duration", time.Hour, "longest account lock")
+	fs.StringVar(&cfg.Auth.TOTPIssuer, "totp-issuer", "Thrive Track", "issuer name shown by authenticator apps")
+
+	fs.StringVar(&cfg.Mail.SMTPHost, "smtp-host", "", "SMTP host, emails are written to mail-dir when empty")
+	fs.IntVar(&cfg.Mail.SMTPPort, "smtp-port", 587, "SMTP port")
+	fs.StringVar(&cfg.Mail.SMTPUsername, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.Mail.SMTPPassword, "smtp-password", "", "SMTP password")
+	fs.StringVar(&cfg.Mail.Sender, "mail-sender", "no-reply@thrivetrack.local", "From address of outgoing emails")
+	fs.StringVar(&cfg.Mail.Dir, "mail-dir", "tmp/mail", "directory emails are written to without SMTP")
+
+	fs.BoolVar(&cfg.Metrics.Enabled, "metrics-enabled", true, "expose Prometheus metrics on /metrics")
+	fs.StringVar(&cfg.Metrics.Addr, "metrics-addr", "", "separate admin listen address for /metrics, the API listener is used when empty")
+	fs.StringVar(&cfg.Tracing.Exporter, "tracing-exporter", "none", "OpenTelemetry trace exporter (none, stdout, otlp or memory)")
+	fs.StringVar(&cfg.Tracing.Endpoint, "tracing-endpoint", "", "OTLP/HTTP collector URL, e.g. http://localhost:4318")
+	fs.Float64Var(&cfg.Tracing.SampleRatio, "tracing-sample-ratio", 1, "share of new traces that are sampled, between 0 and 1")
+
+	return fs
+}
+
+func envName(flagName string) string {
+	return strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Load reads the configuration, args are the command-line arguments without
+// the program name. the config file is -config or CONFIG_FILE, ./.env is
+// used when neither is set and it exists
+func Load(args []string) (*Config, error) {
+	cfg := &Config{}
+	fs := newFlagSet(cfg)
+	var configFile string
+	fs.StringVar(&configFile, "config", "", "path to a .env or YAML config file")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	// flags given on the command line are never overridden
+	explicit := map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	if configFile == "" {
+		configFile = os.Getenv("CONFIG_FILE")
+	}
+	required := configFile != ""
+	if configFile == "" {
+		configFile = ".env"
+	}
+
+	fileValues, err := readFile(configFile)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		fileValues = map[string]string{}
+	} else if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	var setErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if explicit[f.Name] || f.Name == "config" || setErr != nil {
+			return
+		}
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			value, ok = fileValues[envName(f.Name)]
+		}
+		if !ok {
+			return
+		}
+		if err := f.Value.Set(value); err != nil {
+			setErr = fmt.Errorf("invalid value %q for %s: %w", value, envName(f.Name), err)
+		}
+	})
+	if setErr != nil {
+		return nil, setErr
+	}
+
+	err = cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// readFile a YAML file is a flat mapping of settings, a .env file has one
+// KEY=value per line. keys may be written as env names or flag names
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := map[string]string{}
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		scanner := bufio.NewScanner(strings.NewReader(string(data)))
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" || strings.HasPrefix(text, "#") {
+				continue
+			}
+			key, value, ok := strings.Cut(strings.TrimPrefix(text, "export "), "=")
+			if !ok {
+				return nil, fmt.Errorf("%s:%d: expected KEY=value", path, line)
+			}
+			raw[strings.TrimSpace(key)] = strings.Trim(strings.TrimSpace(value), `"'`)
+		}
+	}
+
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		values[envName(key)] = value
+	}
+	return values, nil
+}
+
+// Validate reports every invalid setting at once
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+
+	_, _, err := net.SplitHostPort(cfg.Addr)
+	check(err == nil, "ADDR must be host:port, got %q", cfg.Addr)
+	check(slices.Contains(logLevels, cfg.LogLevel), "LOG_LEVEL must be one of %s", strings.Join(logLevels, ", "))
+	check(slices.Contains(logFormats, cfg.LogFormat), "LOG_FORMAT must be one of %s", strings.Join(logFormats, ", "))
+
+	if cfg.DB.DSN == "" {
+		check(cfg.DB.Host != "", "DB_HOST must be provided")
+		check(cfg.DB.Port > 0 && cfg.DB.Port <= 65535, "DB_PORT must be between 1 and 65535")
+		check(cfg.DB.User != "", "DB_USER must be provided")
+		check(cfg.DB.Name != "", "DB_NAME must be provided")
+	}
+	check(cfg.DB.MaxOpenConns >= 0, "DB_MAX_OPEN_CONNS must not be negative")
+	check(cfg.DB.MaxIdleConns >= 0, "DB_MAX_IDLE_CONNS must not be negative")
+	check(cfg.DB.MaxOpenConns == 0 || cfg.DB.MaxIdleConns <= cfg.DB.MaxOpenConns, "DB_MAX_IDLE_CONNS must not be more than DB_MAX_OPEN_CONNS")
+	check(cfg.DB.ConnMaxIdleTime >= 0, "DB_CONN_MAX_IDLE_TIME must not be negative")
+
+	check(cfg.Server.ReadTimeout > 0, "READ_TIMEOUT must be greater than zero")
+	check(cfg.Server.WriteTimeout > 0, "WRITE_TIMEOUT must be greater than zero")
+	check(cfg.Server.IdleTimeout > 0, "IDLE_TIMEOUT must be greater than zero")
+	check(cfg.Server.ShutdownTimeout > 0, "SHUTDOWN_TIMEOUT must be greater than zero")
+
+	check(cfg.Auth.AccessTokenTTL > 0, "ACCESS_TOKEN_TTL must be greater than zero")
+	check(cfg.Auth.RefreshTokenTTL > cfg.Auth.AccessTokenTTL, "REFRESH_TOKEN_TTL must be longer than ACCESS_TOKEN_TTL")
+	check(cfg.Auth.BcryptCost >= bcrypt.MinCost && cfg.Auth.BcryptCost <= bcrypt.MaxCost, "BCRYPT_COST must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+
+	check(slices.Contains(rateLimitBackends, cfg.Auth.RateLimitBackend), "RATE_LIMIT_BACKEND must be one of %s", strings.Join(rateLimitBackends, ", "))
+	check(cfg.Auth.LoginIPLimit >= 0, "LOGIN_IP_LIMIT must not be negative")
+	check(cfg.Auth.LoginUsernameLimit >= 0, "LOGIN_USERNAME_LIMIT must not be negative")
+	check(cfg.Auth.LockoutThreshold >= 0, "LOCKOUT_THRESHOLD must not be negative")
+	if cfg.Auth.LockoutThreshold > 0 {
+		check(cfg.Auth.LockoutDuration > 0, "LOCKOUT_DURATION must be greater than zero")
+		check(cfg.Auth.LockoutMaxDuration >= cfg.Auth.LockoutDuration, "LOCKOUT_MAX_DURATION must not be less than LOCKOUT_DURATION")
+	}
+	check(cfg.Auth.TOTPIssuer != "", "TOTP_ISSUER must be provided")
+
+	check(cfg.Mail.SMTPPort > 0 && cfg.Mail.SMTPPort <= 65535, "SMTP_PORT must be between 1 and 65535")
+	check(cfg.Mail.Sender != "", "MAIL_SENDER must be provided")
+	check(cfg.Mail.SMTPHost != "" || cfg.Mail.Dir != "", "MAIL_DIR must be provided when SMTP_HOST is not set")
+
+	if cfg.Metrics.Enabled && cfg.Metrics.Addr != "" {
+		_, _, err = net.SplitHostPort(cfg.Metrics.Addr)
+		check(err == nil, "METRICS_ADDR must be host:port, got %q", cfg.Metrics.Addr)
+		check(cfg.Metrics.Addr != cfg.Addr, "METRICS_ADDR must differ from ADDR")
+	}
+
+	check(slices.Contains(traceExporters, cfg.Tracing.Exporter), "TRACING_EXPORTER must be one of %s", strings.Join(traceExporters, ", "))
+	check(cfg.Tracing.SampleRatio >= 0 && cfg.Tracing.SampleRatio <= 1, "TRACING_SAMPLE_RATIO must be between 0 and 1")
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
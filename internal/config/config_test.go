@@ -0,0 +1,84 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeFile(t *testing.T, name, content string) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	cfg, err := Load(nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, ":8080", cfg.Addr)
+	assert.Equal(t, "host=localhost user=root password=postgres dbname=postgres port=5432 sslmode=disable", cfg.DB.ConnString())
+	assert.Equal(t, time.Hour, cfg.Auth.AccessTokenTTL)
+	assert.Equal(t, 12, cfg.Auth.BcryptCost)
+	assert.Equal(t, 30*time.Second, cfg.Server.ShutdownTimeout)
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	path := writeFile(t, "app.env", `
+# comments and blank lines are skipped
+DB_HOST=file-host
+DB_PORT=6543
+export LOG_LEVEL="debug"
+ADDR=:7000
+`)
+	t.Setenv("DB_HOST", "env-host")
+	t.Setenv("ADDR", ":9000")
+
+	cfg, err := Load([]string{"-config", path, "-addr", ":9999"})
+	require.NoError(t, err)
+
+	assert.Equal(t, "env-host", cfg.DB.Host, "env beats the file")
+	assert.Equal(t, 6543, cfg.DB.Port, "the file beats defaults")
+	assert.Equal(t, "debug", cfg.LogLevel)
+	assert.Equal(t, ":9999", cfg.Addr, "flags beat env")
+}
+
+func TestLoadYAML(t *testing.T) {
+	path := writeFile(t, "config.yaml", `
+db-dsn: postgres://app@db/thrive
+access_token_ttl: 15m
+BCRYPT_COST: 10
+`)
+	t.Setenv("CONFIG_FILE", path)
+
+	cfg, err := Load(nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, "postgres://app@db/thrive", cfg.DB.ConnString())
+	assert.Equal(t, 15*time.Minute, cfg.Auth.AccessTokenTTL)
+	assert.Equal(t, 10, cfg.Auth.BcryptCost)
+}
+
+func TestLoadErrors(t *testing.T) {
+	t.Chdir(t.TempDir())
+
+	_, err := Load([]string{"-config", "missing.yaml"})
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	t.Setenv("DB_PORT", "not-a-port")
+	_, err = Load(nil)
+	assert.ErrorContains(t, err, "DB_PORT")
+
+	t.Setenv("DB_PORT", "0")
+	_, err = Load([]string{"-bcrypt-cost", "50", "-log-level", "loud", "-access-token-ttl", "0s", "-metrics-addr", ":8080"})
+	require.Error(t, err)
+	for _, want := range []string{"DB_PORT", "BCRYPT_COST", "LOG_LEVEL", "ACCESS_TOKEN_TTL", "METRICS_ADDR"} {
+		assert.ErrorContains(t, err, want)
+	}
+}
@@ -0,0 +1,61 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// New a JSON or text slog logger writing to w, level is debug, info, warn or error
+func New(w io.Writer, format, level string) (*slog.Logger, error) {
+	var lvl slog.Level
+	err := lvl.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	switch strings.ToLower(format) {
+	case "json":
+		return slog.New(slog.NewJSONHandler(w, opts)), nil
+	case "text":
+		return slog.New(slog.NewTextHandler(w, opts)), nil
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+}
+
+type contextKey struct{}
+
+// requestLogger shared by every layer of a request, so attributes added deep
+// in the chain (the user id after authentication) show up in the access log too
+type requestLogger struct {
+	logger *slog.Logger
+}
+
+// NewContext attaches a request-scoped logger to ctx
+func NewContext(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, &requestLogger{logger: logger})
+}
+
+// FromContext the request-scoped logger, slog.Default outside of a request
+func FromContext(ctx context.Context) *slog.Logger {
+	rl, ok := ctx.Value(contextKey{}).(*requestLogger)
+	if !ok {
+		return slog.Default()
+	}
+	return rl.logger
+}
+
+// AddAttrs adds attributes to the request-scoped logger of ctx, it is a no-op
+// when ctx carries none. a request is served by one goroutine at a time, so
+// no locking is needed
+func AddAttrs(ctx context.Context, args ...any) {
+	rl, ok := ctx.Value(contextKey{}).(*requestLogger)
+	if !ok {
+		return
+	}
+	rl.logger = rl.logger.With(args...)
+}
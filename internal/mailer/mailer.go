@@ -0,0 +1,102 @@
+package mailer
+
+import (
+	"fmt"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mailer delivers plain text emails
+type Mailer interface {
+	Send(recipient, subject, body string) error
+}
+
+type SMTPMailer struct {
+	addr   string
+	auth   smtp.Auth
+	sender string
+}
+
+// NewSMTPMailer auth is skipped when no username is given
+func NewSMTPMailer(host string, port int, username, password, sender string) *SMTPMailer {
+	m := &SMTPMailer{
+		addr:   fmt.Sprintf("%s:%d", host, port),
+		sender: sender,
+	}
+	if username != "" {
+		m.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return m
+}
+
+func (m *SMTPMailer) Send(recipient, subject, body string) error {
+	return smtp.SendMail(m.addr, m.auth, m.sender, []string{recipient}, buildMessage(m.sender, recipient, subject, body))
+}
+
+// FileMailer writes every email to its own file in dir, for local development
+type FileMailer struct {
+	dir    string
+	sender string
+}
+
+func NewFileMailer(dir, sender string) *FileMailer {
+	return &FileMailer{dir: dir, sender: sender}
+}
+
+func (m *FileMailer) Send(recipient, subject, body string) error {
+	err := os.MkdirAll(m.dir, 0o755)
+	if err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d_%s.eml", time.Now().UnixNano(), strings.NewReplacer("@", "_at_", "/", "_").Replace(recipient))
+	return os.WriteFile(filepath.Join(m.dir, name), buildMessage(m.sender, recipient, subject, body), 0o644)
+}
+
+type Message struct {
+	Recipient string
+	Subject   string
+	Body      string
+}
+
+// MemoryMailer keeps the emails it was asked to send, for tests
+type MemoryMailer struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func NewMemoryMailer() *MemoryMailer {
+	return &MemoryMailer{}
+}
+
+func (m *MemoryMailer) Send(recipient, subject, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.messages = append(m.messages, Message{Recipient: recipient, Subject: subject, Body: body})
+	return nil
+}
+
+// Messages a copy of everything sent so far, oldest first
+func (m *MemoryMailer) Messages() []Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]Message(nil), m.messages...)
+}
+
+func buildMessage(sender, recipient, subject, body string) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", sender)
+	fmt.Fprintf(&b, "To: %s\r\n", recipient)
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=\"utf-8\"\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(body)
+	return []byte(b.String())
+}
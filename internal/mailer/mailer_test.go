@@ -0,0 +1,36 @@
+package mailer
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileMailer(t *testing.T) {
+	dir := t.TempDir()
+	m := NewFileMailer(dir, "no-reply@thrivetrack.local")
+
+	require.NoError(t, m.Send("jane@example.com", "Reset your password", "token: ABC"))
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+
+	content, err := os.ReadFile(files[0])
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "To: jane@example.com\r\n")
+	assert.Contains(t, string(content), "Subject: Reset your password\r\n")
+	assert.Contains(t, string(content), "\r\n\r\ntoken: ABC")
+}
+
+func TestMemoryMailer(t *testing.T) {
+	m := NewMemoryMailer()
+	require.NoError(t, m.Send("jane@example.com", "hello", "body"))
+
+	messages := m.Messages()
+	require.Len(t, messages, 1)
+	assert.Equal(t, Message{Recipient: "jane@example.com", Subject: "hello", Body: "body"}, messages[0])
+}
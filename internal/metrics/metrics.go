@@ -0,0 +1,137 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "thrivetrack"
+
+// Metrics every collector of the service on its own registry. a nil *Metrics
+// is valid and records nothing, that is how metrics are turned off
+type Metrics struct {
+	registry *prometheus.Registry
+
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	tokensIssued    *prometheus.CounterVec
+	failedLogins    prometheus.Counter
+	workoutsCreated prometheus.Counter
+	entriesLogged   prometheus.Counter
+}
+
+// New registers the HTTP, token and business collectors plus the pool stats of db
+func New(db *sql.DB) *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "HTTP requests by route pattern, method and status.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "HTTP request latency by route pattern, method and status.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route", "status"}),
+		tokensIssued: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tokens_issued_total",
+			Help:      "Tokens handed out, by scope.",
+		}, []string{"scope"}),
+		failedLogins: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "failed_logins_total",
+			Help:      "Login attempts with an unknown user or a wrong password.",
+		}),
+		workoutsCreated: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "workouts_created_total",
+			Help:      "Workouts created, directly or from a template.",
+		}),
+		entriesLogged: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "workout_entries_logged_total",
+			Help:      "Exercise entries of the created workouts.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.tokensIssued,
+		m.failedLogins,
+		m.workoutsCreated,
+		m.entriesLogged,
+		collectors.NewDBStatsCollector(db, "postgres"),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the registry in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{Registry: m.registry})
+}
+
+// Middleware counts and times every request. the route is the chi pattern,
+// /workouts/{id} rather than /workouts/42, so the label stays bounded
+func (m *Metrics) Middleware(next http.Handler) http.Handler {
+	if m == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		labels := prometheus.Labels{"method": r.Method, "route": route, "status": strconv.Itoa(status)}
+		m.requests.With(labels).Inc()
+		m.requestDuration.With(labels).Observe(time.Since(start).Seconds())
+	})
+}
+
+// TokensIssued counts one token per scope given
+func (m *Metrics) TokensIssued(scopes ...string) {
+	if m == nil {
+		return
+	}
+	for _, scope := range scopes {
+		m.tokensIssued.WithLabelValues(scope).Inc()
+	}
+}
+
+func (m *Metrics) FailedLogin() {
+	if m == nil {
+		return
+	}
+	m.failedLogins.Inc()
+}
+
+// WorkoutCreated counts the workout and its entries
+func (m *Metrics) WorkoutCreated(entries int) {
+	if m == nil {
+		return
+	}
+	m.workoutsCreated.Inc()
+	m.entriesLogged.Add(float64(entries))
+}
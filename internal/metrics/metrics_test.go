@@ -0,0 +1,63 @@
+package metrics
+
+import (
+	"database/sql"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetrics(t *testing.T) {
+	// sql.Open doesn't connect, the pool stats are all zero
+	db, err := sql.Open("pgx", "host=localhost")
+	require.NoError(t, err)
+	defer db.Close()
+
+	m := New(db)
+	r := chi.NewRouter()
+	r.Use(m.Middleware)
+	r.Get("/workouts/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	r.Handle("/metrics", m.Handler())
+
+	for _, path := range []string{"/workouts/1", "/workouts/2", "/nowhere"} {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	m.TokensIssued("authentication", "refresh")
+	m.FailedLogin()
+	m.WorkoutCreated(3)
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+
+	assert.Contains(t, body, `thrivetrack_http_requests_total{method="GET",route="/workouts/{id}",status="404"} 2`)
+	assert.Contains(t, body, `thrivetrack_http_requests_total{method="GET",route="unmatched",status="404"} 1`)
+	assert.Contains(t, body, `thrivetrack_http_request_duration_seconds_bucket{method="GET",route="/workouts/{id}",status="404"`)
+	assert.Contains(t, body, `thrivetrack_tokens_issued_total{scope="refresh"} 1`)
+	assert.Contains(t, body, `thrivetrack_failed_logins_total 1`)
+	assert.Contains(t, body, `thrivetrack_workouts_created_total 1`)
+	assert.Contains(t, body, `thrivetrack_workout_entries_logged_total 3`)
+	assert.Contains(t, body, `go_sql_open_connections{db_name="postgres"} 0`)
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	assert.NotPanics(t, func() {
+		m.TokensIssued("authentication")
+		m.FailedLogin()
+		m.WorkoutCreated(1)
+	})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	w := httptest.NewRecorder()
+	m.Middleware(next).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
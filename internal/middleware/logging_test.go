@@ -0,0 +1,68 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/nickemma/internal/logging"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLogging(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	var seenID string
+	handler := RequestID(logger)(AccessLog(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seenID = GetRequestID(r)
+		// what Authenticate does for logged in users
+		logging.AddAttrs(r.Context(), "user_id", 7)
+		w.WriteHeader(http.StatusTeapot)
+	})))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{name: "propagated", incoming: "abc-123", keep: true},
+		{name: "generated", incoming: "", keep: false},
+		{name: "rejected", incoming: "bad id\n" + strings.Repeat("x", 200), keep: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs.Reset()
+			r := httptest.NewRequest(http.MethodGet, "/workouts?page=2", nil)
+			if tt.incoming != "" {
+				r.Header.Set(RequestIDHeader, tt.incoming)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			id := w.Header().Get(RequestIDHeader)
+			require.NotEmpty(t, id)
+			assert.Equal(t, id, seenID)
+			if tt.keep {
+				assert.Equal(t, tt.incoming, id)
+			} else {
+				assert.NotEqual(t, tt.incoming, id)
+			}
+
+			var line map[string]any
+			require.NoError(t, json.Unmarshal(logs.Bytes(), &line))
+			assert.Equal(t, "request", line["msg"])
+			assert.Equal(t, id, line["request_id"])
+			assert.Equal(t, float64(7), line["user_id"])
+			assert.Equal(t, "GET", line["method"])
+			assert.Equal(t, "/workouts", line["path"])
+			assert.Equal(t, float64(http.StatusTeapot), line["status"])
+		})
+	}
+}
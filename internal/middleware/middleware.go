@@ -2,9 +2,11 @@ package middleware
 
 import (
 	"context"
+	"errors"
 	"net/http"
 	"strings"
 
+	"github.com/nickemma/internal/logging"
 	"github.com/nickemma/internal/store"
 	"github.com/nickemma/internal/tokens"
 	"github.com/nickemma/internal/utils"
@@ -17,6 +19,7 @@ type UserMiddleware struct {
 type contextKey string
 
 const UserContextKey = contextKey("user")
+const TokenContextKey = contextKey("token")
 
 func SetUser(r *http.Request, user *store.User) *http.Request {
 	ctx := context.WithValue(r.Context(), UserContextKey, user)
@@ -31,6 +34,18 @@ func GetUser(r *http.Request) *store.User {
 	return user
 }
 
+// SetToken keeps the bearer token of the request so it can be revoked later on
+func SetToken(r *http.Request, token string) *http.Request {
+	ctx := context.WithValue(r.Context(), TokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// GetToken returns an empty string for anonymous requests
+func GetToken(r *http.Request) string {
+	token, _ := r.Context().Value(TokenContextKey).(string)
+	return token
+}
+
 func (um *UserMiddleware) Authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// within this anonymouse function
@@ -52,18 +67,20 @@ func (um *UserMiddleware) Authenticate(next http.Handler) http.Handler {
 		}
 
 		token := headerParts[1]
-		user, err := um.UserStore.GetUserToken(tokens.ScopeAuth, token)
-		if err != nil {
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid token"})
+		user, err := um.UserStore.GetUserToken(r.Context(), tokens.ScopeAuth, token)
+		if errors.Is(err, store.ErrNotFound) {
+			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "token expired or invalid"})
 			return
 		}
-
-		if user == nil {
-			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "token expired or invalid"})
+		if err != nil {
+			logging.FromContext(r.Context()).Error("looking up auth token", "error", err)
+			utils.WriteJSON(w, http.StatusUnauthorized, utils.Envelope{"error": "invalid token"})
 			return
 		}
 
+		logging.AddAttrs(r.Context(), "user_id", user.ID)
 		r = SetUser(r, user)
+		r = SetToken(r, token)
 		next.ServeHTTP(w, r)
 		return
 	})
@@ -81,3 +98,33 @@ func (um *UserMiddleware) RequireUser(next http.HandlerFunc) http.HandlerFunc {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// RequireActivatedUser like RequireUser, but the account must also have a verified email
+func (um *UserMiddleware) RequireActivatedUser(next http.HandlerFunc) http.HandlerFunc {
+	return um.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r)
+
+		if !user.Activated {
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "your account must be activated to access this route"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequirePermission like RequireUser, but the role of the user must also
+// grant the permission
+func (um *UserMiddleware) RequirePermission(permission string, next http.HandlerFunc) http.HandlerFunc {
+	return um.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		user := GetUser(r)
+
+		if !user.HasPermission(permission) {
+			logging.FromContext(r.Context()).Warn("permission denied", "permission", permission)
+			utils.WriteJSON(w, http.StatusForbidden, utils.Envelope{"error": "you don't have permission to access this route"})
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/nickemma/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequireActivatedUser(t *testing.T) {
+	um := &UserMiddleware{}
+	handler := um.RequireActivatedUser(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		user   *store.User
+		status int
+	}{
+		{name: "anonymous", user: store.AnonymousUser, status: http.StatusUnauthorized},
+		{name: "not activated", user: &store.User{ID: 1}, status: http.StatusForbidden},
+		{name: "activated", user: &store.User{ID: 1, Activated: true}, status: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := SetUser(httptest.NewRequest(http.MethodPost, "/workouts", nil), tt.user)
+			w := httptest.NewRecorder()
+			handler(w, r)
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
+
+func TestRequirePermission(t *testing.T) {
+	um := &UserMiddleware{}
+	handler := um.RequirePermission(store.PermissionUsersRead, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	tests := []struct {
+		name   string
+		user   *store.User
+		status int
+	}{
+		{name: "anonymous", user: store.AnonymousUser, status: http.StatusUnauthorized},
+		{name: "no permissions", user: &store.User{ID: 1, Role: store.RoleUser}, status: http.StatusForbidden},
+		{name: "other permission", user: &store.User{ID: 1, Permissions: []string{store.PermissionAuditRead}}, status: http.StatusForbidden},
+		{name: "permitted", user: &store.User{ID: 1, Role: store.RoleAdmin, Permissions: []string{store.PermissionUsersRead}}, status: http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := SetUser(httptest.NewRequest(http.MethodGet, "/admin/users", nil), tt.user)
+			w := httptest.NewRecorder()
+			handler(w, r)
+			assert.Equal(t, tt.status, w.Code)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/nickemma/internal/logging"
+	"github.com/nickemma/internal/utils"
+)
+
+// Backend keeps the token buckets. rate is in tokens per second, a bucket
+// holds at most burst tokens and starts full. when the request is refused
+// the duration is how long until a token is available
+type Backend interface {
+	Take(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error)
+}
+
+// Limiter a token bucket per key. a nil *Limiter allows everything, that
+// is how a limit is turned off
+type Limiter struct {
+	backend Backend
+	name    string
+	rate    float64
+	burst   int
+}
+
+// New allows perMinute requests per key and minute, all of them at once if
+// the bucket is full. zero or less turns the limiter off and returns nil
+func New(backend Backend, name string, perMinute int) *Limiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	return &Limiter{
+		backend: backend,
+		name:    name,
+		rate:    float64(perMinute) / 60,
+		burst:   perMinute,
+	}
+}
+
+// Allow takes a token from the bucket of key, the keys of different limiters
+// never collide even when they share a backend
+func (l *Limiter) Allow(ctx context.Context, key string) (bool, time.Duration, error) {
+	if l == nil {
+		return true, 0, nil
+	}
+	return l.backend.Take(ctx, l.name+":"+key, l.rate, l.burst)
+}
+
+// Middleware limits requests per client IP. when the backend fails the
+// request goes through, an outage of the limiter shouldn't take logins down
+func (l *Limiter) Middleware(next http.Handler) http.Handler {
+	if l == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		allowed, retryAfter, err := l.Allow(r.Context(), ClientIP(r))
+		if err != nil {
+			logging.FromContext(r.Context()).Error("rate limiter", "limiter", l.name, "error", err)
+		} else if !allowed {
+			logging.FromContext(r.Context()).Warn("rate limit exceeded", "limiter", l.name, "client_ip", ClientIP(r))
+			utils.RateLimitExceeded(w, retryAfter)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// ClientIP the address of the connection, X-Forwarded-For is not trusted
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryBackend buckets of a single instance, each instance counts on its own
+type MemoryBackend struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// sweepInterval how often full buckets are dropped, a missing bucket is a full one
+const sweepInterval = time.Minute
+
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		buckets: map[string]*bucket{},
+		now:     time.Now,
+	}
+}
+
+func (m *MemoryBackend) Take(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if now.Sub(m.lastSweep) > sweepInterval {
+		m.sweep(now, rate, burst)
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(burst), updated: now}
+		m.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rate * float64(time.Second)), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+// sweep drops the buckets that have refilled completely. buckets of slower
+// limiters may be dropped a bit early, which only makes them more lenient
+func (m *MemoryBackend) sweep(now time.Time, rate float64, burst int) {
+	full := time.Duration(float64(burst) / rate * float64(time.Second))
+	for key, b := range m.buckets {
+		if now.Sub(b.updated) > full {
+			delete(m.buckets, key)
+		}
+	}
+	m.lastSweep = now
+}
@@ -0,0 +1,81 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBackend(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	backend := NewMemoryBackend()
+	backend.now = func() time.Time { return now }
+
+	// 3 per minute, one token every 20 seconds
+	limiter := New(backend, "login", 3)
+
+	for i := 0; i < 3; i++ {
+		allowed, _, err := limiter.Allow(ctx, "alice")
+		require.NoError(t, err)
+		assert.True(t, allowed, "attempt %d", i)
+	}
+
+	allowed, retryAfter, err := limiter.Allow(ctx, "alice")
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Equal(t, 20*time.Second, retryAfter)
+
+	allowed, _, _ = limiter.Allow(ctx, "bob")
+	assert.True(t, allowed, "keys have their own bucket")
+
+	now = now.Add(20 * time.Second)
+	allowed, _, _ = limiter.Allow(ctx, "alice")
+	assert.True(t, allowed, "a token is back after 20 seconds")
+	allowed, _, _ = limiter.Allow(ctx, "alice")
+	assert.False(t, allowed)
+
+	// a bucket that refilled completely is swept, the next take starts full
+	now = now.Add(time.Hour)
+	allowed, _, _ = limiter.Allow(ctx, "carol")
+	assert.True(t, allowed)
+	assert.NotContains(t, backend.buckets, "login:alice")
+}
+
+func TestMiddleware(t *testing.T) {
+	limiter := New(NewMemoryBackend(), "login-ip", 1)
+	handler := limiter.Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	}))
+
+	send := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/tokens/authentication", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	assert.Equal(t, http.StatusCreated, send("10.0.0.1:5000").Code)
+
+	w := send("10.0.0.1:5001")
+	assert.Equal(t, http.StatusTooManyRequests, w.Code)
+	assert.Equal(t, "60", w.Header().Get("Retry-After"))
+	assert.Contains(t, w.Body.String(), "too many requests")
+
+	assert.Equal(t, http.StatusCreated, send("10.0.0.2:5000").Code)
+}
+
+func TestDisabledLimiter(t *testing.T) {
+	limiter := New(NewMemoryBackend(), "login-ip", 0)
+	assert.Nil(t, limiter)
+
+	allowed, _, err := limiter.Allow(context.Background(), "anyone")
+	require.NoError(t, err)
+	assert.True(t, allowed)
+}
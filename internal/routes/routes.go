@@ -3,20 +3,102 @@ package routes
 import (
 	"github.com/go-chi/chi/v5"
 	"github.com/nickemma/internal/app"
+	"github.com/nickemma/internal/middleware"
+	"github.com/nickemma/internal/store"
+	"github.com/nickemma/internal/tracing"
 )
 
 func SetUpRoute(app *app.Application) *chi.Mux {
 	r := chi.NewRouter()
+	r.Use(tracing.Middleware)
+	r.Use(middleware.RequestID(app.Logger))
+	r.Use(middleware.AccessLog)
+	r.Use(app.Metrics.Middleware)
 
-	r.Get("/health", app.HealthCheck)
-	r.Get("/workouts/{id}", app.WorkoutHandler.HandlerGetWorkoutByID)
+	r.Group(func(r chi.Router) {
+		r.Use(app.Middleware.Authenticate)
+
+		r.Get("/workouts", app.Middleware.RequireUser(app.WorkoutHandler.HandleListWorkouts))
+		r.Get("/workouts/{id}", app.Middleware.RequireUser(app.WorkoutHandler.HandlerGetWorkoutByID))
+
+		r.Post("/workouts", app.Middleware.RequireActivatedUser(app.WorkoutHandler.HandlerCreateWorkout))
+		r.Put("/workouts/{id}", app.Middleware.RequireActivatedUser(app.WorkoutHandler.HandleUpdateWorkoutById))
+		r.Delete("/workouts/{id}", app.Middleware.RequireActivatedUser(app.WorkoutHandler.HandleDeleteWorkoutById))
+		r.Get("/workouts/{id}/comments", app.Middleware.RequireUser(app.WorkoutHandler.HandleListWorkoutComments))
+		r.Post("/workouts/{id}/entries/{entryID}/comments", app.Middleware.RequireActivatedUser(app.WorkoutHandler.HandleAddEntryComment))
+
+		r.Get("/templates", app.Middleware.RequireUser(app.TemplateHandler.HandleListTemplates))
+		r.Get("/templates/{id}", app.Middleware.RequireUser(app.TemplateHandler.HandleGetTemplateByID))
+		r.Post("/templates", app.Middleware.RequireActivatedUser(app.TemplateHandler.HandleCreateTemplate))
+		r.Put("/templates/{id}", app.Middleware.RequireActivatedUser(app.TemplateHandler.HandleUpdateTemplateByID))
+		r.Delete("/templates/{id}", app.Middleware.RequireActivatedUser(app.TemplateHandler.HandleDeleteTemplateByID))
+		r.Post("/templates/{id}/start", app.Middleware.RequireActivatedUser(app.TemplateHandler.HandleStartTemplate))
+
+		r.Get("/exercises", app.Middleware.RequireUser(app.ExerciseHandler.HandleSearchExercises))
+		r.Get("/exercises/{id}", app.Middleware.RequireUser(app.ExerciseHandler.HandleGetExerciseByID))
+		r.Post("/exercises", app.Middleware.RequireActivatedUser(app.ExerciseHandler.HandleCreateExercise))
+		r.Put("/exercises/{id}", app.Middleware.RequireActivatedUser(app.ExerciseHandler.HandleUpdateExerciseByID))
+		r.Delete("/exercises/{id}", app.Middleware.RequireActivatedUser(app.ExerciseHandler.HandleDeleteExerciseByID))
+		r.Get("/exercises/{id}/records/history", app.Middleware.RequireUser(app.RecordHandler.HandleGetExerciseRecordHistory))
+
+		r.Get("/users/me", app.Middleware.RequireUser(app.UserHandler.HandleGetMe))
+		r.Patch("/users/me", app.Middleware.RequireUser(app.UserHandler.HandleUpdateMe))
+		r.Put("/users/me/password", app.Middleware.RequireUser(app.UserHandler.HandleChangePassword))
+		r.Delete("/users/me", app.Middleware.RequireUser(app.UserHandler.HandleDeleteMe))
+		r.Get("/users/me/records", app.Middleware.RequireUser(app.RecordHandler.HandleGetMyRecords))
+		r.Post("/users/me/2fa/setup", app.Middleware.RequireUser(app.TwoFactorHandler.HandleSetupTwoFactor))
+		r.Post("/users/me/2fa/verify", app.Middleware.RequireUser(app.TwoFactorHandler.HandleVerifyTwoFactor))
+		r.Post("/users/me/2fa/recovery-codes", app.Middleware.RequireUser(app.TwoFactorHandler.HandleRegenerateRecoveryCodes))
+		r.Delete("/users/me/2fa", app.Middleware.RequireUser(app.TwoFactorHandler.HandleDisableTwoFactor))
+		r.Get("/users/me/coaches", app.Middleware.RequireUser(app.CoachHandler.HandleListCoaches))
+		r.Post("/users/me/coaches", app.Middleware.RequireActivatedUser(app.CoachHandler.HandleAcceptInvite))
+		r.Delete("/users/me/coaches/{id}", app.Middleware.RequireUser(app.CoachHandler.HandleRemoveCoach))
+		r.Get("/users/me/calendar", app.Middleware.RequireUser(app.CoachHandler.HandleGetCalendar))
+
+		r.Delete("/tokens/authentication", app.Middleware.RequireUser(app.TokenHandler.HandleRevokeToken))
+		r.Delete("/tokens/authentication/all", app.Middleware.RequireUser(app.TokenHandler.HandleRevokeAllTokens))
+		r.Get("/tokens/sessions", app.Middleware.RequireUser(app.TokenHandler.HandleListSessions))
 
-	r.Post("/workouts", app.WorkoutHandler.HandlerCreateWorkout)
-	r.Put("/workouts/{id}", app.WorkoutHandler.HandleUpdateWorkoutById)
-	r.Delete("/workouts/{id}", app.WorkoutHandler.HandleDeleteWorkoutById)
+		r.Get("/analytics/summary", app.Middleware.RequireUser(app.AnalyticsHandler.HandleTrainingSummary))
+		r.Get("/analytics/exercises/{name}/progression", app.Middleware.RequireUser(app.AnalyticsHandler.HandleExerciseProgression))
 
+		r.Get("/coach/athletes", app.Middleware.RequirePermission(store.PermissionCoachAthletes, app.CoachHandler.HandleListAthletes))
+		r.Post("/coach/athletes", app.Middleware.RequirePermission(store.PermissionCoachAthletes, app.CoachHandler.HandleInviteAthlete))
+		r.Delete("/coach/athletes/{id}", app.Middleware.RequirePermission(store.PermissionCoachAthletes, app.CoachHandler.HandleRemoveAthlete))
+		r.Get("/coach/athletes/{id}/workouts", app.Middleware.RequirePermission(store.PermissionCoachAthletes, app.WorkoutHandler.HandleListAthleteWorkouts))
+		r.Get("/coach/athletes/{id}/calendar", app.Middleware.RequirePermission(store.PermissionCoachAthletes, app.CoachHandler.HandleGetAthleteCalendar))
+		r.Post("/coach/athletes/{id}/planned-workouts", app.Middleware.RequirePermission(store.PermissionCoachAthletes, app.CoachHandler.HandlePlanWorkout))
+
+		r.Get("/admin/users", app.Middleware.RequirePermission(store.PermissionUsersRead, app.AdminHandler.HandleListUsers))
+		r.Get("/admin/users/{id}", app.Middleware.RequirePermission(store.PermissionUsersRead, app.AdminHandler.HandleGetUser))
+		r.Put("/admin/users/{id}/disabled", app.Middleware.RequirePermission(store.PermissionUsersManage, app.AdminHandler.HandleSetUserDisabled))
+		r.Put("/admin/users/{id}/role", app.Middleware.RequirePermission(store.PermissionUsersManage, app.AdminHandler.HandleSetUserRole))
+		r.Delete("/admin/users/{id}/tokens", app.Middleware.RequirePermission(store.PermissionUsersManage, app.AdminHandler.HandleRevokeUserTokens))
+		r.Get("/admin/workouts/{id}", app.Middleware.RequirePermission(store.PermissionWorkoutsReadAny, app.AdminHandler.HandleGetWorkout))
+		r.Get("/admin/audit-log", app.Middleware.RequirePermission(store.PermissionAuditRead, app.AdminHandler.HandleListAuditLog))
+	})
+
+	r.Get("/health", app.HealthCheck)
+	r.Get("/health/live", app.HealthLive)
+	r.Get("/health/ready", app.HealthReady)
+	if app.Metrics != nil && app.Config.Metrics.Addr == "" {
+		r.Handle("/metrics", app.Metrics.Handler())
+	}
 	r.Post("/users", app.UserHandler.HandleRegisterUser)
-	r.Post("/tokens/authentication", app.TokenHandler.HandleCreateToken)
+	r.With(app.LoginLimiter.Middleware).Post("/tokens/authentication", app.TokenHandler.HandleCreateToken)
+	r.With(app.LoginLimiter.Middleware).Post("/tokens/2fa", app.TokenHandler.HandleCreateTwoFactorToken)
+	r.Post("/tokens/refresh", app.TokenHandler.HandleRefreshToken)
+	r.Post("/tokens/password-reset", app.TokenHandler.HandleCreatePasswordResetToken)
+	r.Post("/tokens/activation", app.UserHandler.HandleCreateActivationToken)
+	r.Put("/users/password", app.UserHandler.HandleResetPassword)
+	r.Put("/users/activated", app.UserHandler.HandleActivateUser)
 
 	return r
 }
+
+// SetUpAdminRoute the routes of the separate admin listener
+func SetUpAdminRoute(app *app.Application) *chi.Mux {
+	r := chi.NewRouter()
+	r.Handle("/metrics", app.Metrics.Handler())
+	return r
+}
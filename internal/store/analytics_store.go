@@ -0,0 +1,239 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/nickemma/internal/strength"
+)
+
+var ErrInvalidBucket = errors.New("bucket must be day, week or month")
+
+// ProgressionFilter the exercise is matched on its catalog entry when the
+// name is known to the catalog, on the logged name otherwise
+type ProgressionFilter struct {
+	UserID   int
+	Exercise string
+	From     *time.Time
+	To       *time.Time
+	Bucket   string // day, week or month
+	Formula  strength.Formula
+}
+
+// ProgressionPoint the totals of one time bucket. AverageIntensity is the
+// average load per rep, volume / reps
+type ProgressionPoint struct {
+	BucketStart      time.Time `json:"bucket_start"`
+	BestE1RM         float64   `json:"best_e1rm"`
+	TotalVolume      float64   `json:"total_volume"`
+	TotalSets        int       `json:"total_sets"`
+	TotalReps        int       `json:"total_reps"`
+	AverageIntensity float64   `json:"average_intensity"`
+}
+
+type PostgresAnalyticsStore struct {
+	db *sql.DB
+	queryTimeout
+}
+
+func NewPostgresAnalyticsStore(db *sql.DB) *PostgresAnalyticsStore {
+	return &PostgresAnalyticsStore{db: db, queryTimeout: queryTimeout{timeout: AnalyticsQueryTimeout}}
+}
+
+type AnalyticsStore interface {
+	GetExerciseProgression(ctx context.Context, filter ProgressionFilter) ([]ProgressionPoint, error)
+	GetTrainingSummary(ctx context.Context, userID int, period string, at time.Time) (*TrainingSummary, error)
+}
+
+// GetExerciseProgression buckets every weighted working set of the exercise.
+// the buckets come from postgres, the e1RM is computed here so the formula
+// can be picked per request
+func (pg *PostgresAnalyticsStore) GetExerciseProgression(ctx context.Context, filter ProgressionFilter) ([]ProgressionPoint, error) {
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	if filter.Bucket == "" {
+		filter.Bucket = "week"
+	}
+	if filter.Bucket != "day" && filter.Bucket != "week" && filter.Bucket != "month" {
+		return nil, ErrInvalidBucket
+	}
+
+	entry := WorkoutEntry{ExerciseName: filter.Exercise}
+	err := resolveExercise(ctx, pg.db, filter.UserID, &entry)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+SELECT date_trunc($1, workout_created_at AT TIME ZONE 'UTC') AS bucket, reps, weight
+FROM workout_set_facts
+WHERE user_id = $2
+  AND (exercise_id = $3::bigint OR LOWER(exercise_name) = LOWER($4))
+  AND set_type <> 'warmup'
+  AND reps > 0 AND weight > 0
+  AND ($5::timestamptz IS NULL OR workout_created_at >= $5::timestamptz)
+  AND ($6::timestamptz IS NULL OR workout_created_at < $6::timestamptz)
+ORDER BY bucket
+`
+	rows, err := pg.db.QueryContext(ctx, query, filter.Bucket, filter.UserID, entry.ExerciseID, entry.ExerciseName, filter.From, filter.To)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []ProgressionPoint{}
+	var current *ProgressionPoint
+	for rows.Next() {
+		var bucket time.Time
+		var reps int
+		var weight float64
+		err = rows.Scan(&bucket, &reps, &weight)
+		if err != nil {
+			return nil, err
+		}
+
+		if current == nil || !current.BucketStart.Equal(bucket) {
+			points = append(points, ProgressionPoint{BucketStart: bucket})
+			current = &points[len(points)-1]
+		}
+
+		current.TotalSets++
+		current.TotalReps += reps
+		current.TotalVolume += float64(reps) * weight
+		if e1rm := filter.Formula.Estimate(weight, reps); e1rm > current.BestE1RM {
+			current.BestE1RM = e1rm
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range points {
+		points[i].BestE1RM = roundRecord(points[i].BestE1RM)
+		points[i].TotalVolume = roundRecord(points[i].TotalVolume)
+		points[i].AverageIntensity = roundRecord(points[i].TotalVolume / float64(points[i].TotalReps))
+	}
+
+	return points, nil
+}
+
+var ErrInvalidPeriod = errors.New("period must be week or month")
+
+// PeriodTotals the training totals of one week or month
+type PeriodTotals struct {
+	Start             time.Time `json:"start"`
+	End               time.Time `json:"end"`
+	Sessions          int       `json:"sessions"`
+	DurationMinutes   int       `json:"duration_minutes"`
+	CaloriesBurned    int       `json:"calories_burned"`
+	VolumeLoad        float64   `json:"volume_load"`
+	DistinctExercises int       `json:"distinct_exercises"`
+}
+
+// MetricChange Percent is nil when the previous period was zero
+type MetricChange struct {
+	Delta   float64  `json:"delta"`
+	Percent *float64 `json:"percent"`
+}
+
+type TrainingSummary struct {
+	Period   string                  `json:"period"`
+	Current  PeriodTotals            `json:"current"`
+	Previous PeriodTotals            `json:"previous"`
+	Change   map[string]MetricChange `json:"change"`
+}
+
+// PeriodBounds the week (starting monday) or month containing at, in UTC
+func PeriodBounds(period string, at time.Time) (time.Time, time.Time, error) {
+	at = at.UTC()
+	day := time.Date(at.Year(), at.Month(), at.Day(), 0, 0, 0, 0, time.UTC)
+	switch period {
+	case "week":
+		offset := (int(day.Weekday()) + 6) % 7
+		start := day.AddDate(0, 0, -offset)
+		return start, start.AddDate(0, 0, 7), nil
+	case "month":
+		start := time.Date(at.Year(), at.Month(), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0), nil
+	}
+	return time.Time{}, time.Time{}, ErrInvalidPeriod
+}
+
+// GetTrainingSummary totals of the period containing at, compared with the
+// period right before it
+func (pg *PostgresAnalyticsStore) GetTrainingSummary(ctx context.Context, userID int, period string, at time.Time) (*TrainingSummary, error) {
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	start, end, err := PeriodBounds(period, at)
+	if err != nil {
+		return nil, err
+	}
+	previousStart, _, err := PeriodBounds(period, start.AddDate(0, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &TrainingSummary{Period: period}
+	summary.Current, err = pg.getPeriodTotals(ctx, userID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	summary.Previous, err = pg.getPeriodTotals(ctx, userID, previousStart, start)
+	if err != nil {
+		return nil, err
+	}
+
+	current, previous := summary.Current, summary.Previous
+	summary.Change = map[string]MetricChange{
+		"sessions":           compareMetric(float64(current.Sessions), float64(previous.Sessions)),
+		"duration_minutes":   compareMetric(float64(current.DurationMinutes), float64(previous.DurationMinutes)),
+		"calories_burned":    compareMetric(float64(current.CaloriesBurned), float64(previous.CaloriesBurned)),
+		"volume_load":        compareMetric(current.VolumeLoad, previous.VolumeLoad),
+		"distinct_exercises": compareMetric(float64(current.DistinctExercises), float64(previous.DistinctExercises)),
+	}
+
+	return summary, nil
+}
+
+// getPeriodTotals both queries are served by idx_workouts_user_created_at,
+// volume excludes warmup sets like the progression does
+func (pg *PostgresAnalyticsStore) getPeriodTotals(ctx context.Context, userID int, start, end time.Time) (PeriodTotals, error) {
+	totals := PeriodTotals{Start: start, End: end}
+
+	query := `
+SELECT COUNT(*), COALESCE(SUM(duration_minutes), 0), COALESCE(SUM(calories_burned), 0)
+FROM workouts
+WHERE user_id = $1 AND created_at >= $2 AND created_at < $3
+`
+	err := pg.db.QueryRowContext(ctx, query, userID, start, end).Scan(&totals.Sessions, &totals.DurationMinutes, &totals.CaloriesBurned)
+	if err != nil {
+		return totals, err
+	}
+
+	query = `
+SELECT COALESCE(SUM(reps * weight) FILTER (WHERE set_type <> 'warmup'), 0)::float8,
+       COUNT(DISTINCT COALESCE(exercise_id::text, LOWER(exercise_name)))
+FROM workout_set_facts
+WHERE user_id = $1 AND workout_created_at >= $2 AND workout_created_at < $3
+`
+	err = pg.db.QueryRowContext(ctx, query, userID, start, end).Scan(&totals.VolumeLoad, &totals.DistinctExercises)
+	if err != nil {
+		return totals, err
+	}
+	totals.VolumeLoad = roundRecord(totals.VolumeLoad)
+
+	return totals, nil
+}
+
+func compareMetric(current, previous float64) MetricChange {
+	change := MetricChange{Delta: roundRecord(current - previous)}
+	if previous != 0 {
+		percent := roundRecord((current - previous) / previous * 100)
+		change.Percent = &percent
+	}
+	return change
+}
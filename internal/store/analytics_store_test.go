@@ -0,0 +1,130 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nickemma/internal/strength"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExerciseProgression(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	workoutStore := NewPostgresWorkoutStore(db)
+	analyticsStore := NewPostgresAnalyticsStore(db)
+	user := createTestUser(t, db, "progressor")
+
+	// two sessions in the first week of march, one in the second
+	dates := []time.Time{
+		time.Date(2025, 3, 3, 9, 0, 0, 0, time.UTC),
+		time.Date(2025, 3, 5, 9, 0, 0, 0, time.UTC),
+		time.Date(2025, 3, 10, 9, 0, 0, 0, time.UTC),
+	}
+	weights := []float64{100, 105, 110}
+	for i, date := range dates {
+		workout, err := workoutStore.CreateWorkout(ctx, &Workout{
+			UserID:          user.ID,
+			Title:           "squat day",
+			DurationMinutes: 45,
+			Entries: []WorkoutEntry{
+				{ExerciseName: "Back Squat", Sets: []WorkoutSet{
+					{SetType: SetTypeWarmup, Reps: IntPointer(5), Weight: FloatPointer(60)},
+					{Reps: IntPointer(5), Weight: FloatPointer(weights[i])},
+					{Reps: IntPointer(5), Weight: FloatPointer(weights[i])},
+				}, OrderIndex: 1},
+			},
+		})
+		require.NoError(t, err)
+		_, err = db.Exec(`UPDATE workouts SET created_at = $1 WHERE id = $2`, date, workout.ID)
+		require.NoError(t, err)
+	}
+
+	points, err := analyticsStore.GetExerciseProgression(ctx, ProgressionFilter{
+		UserID:   user.ID,
+		Exercise: "squats",
+		Bucket:   "week",
+		Formula:  strength.FormulaEpley,
+	})
+	require.NoError(t, err)
+	require.Len(t, points, 2)
+
+	assert.Equal(t, 4, points[0].TotalSets)
+	assert.Equal(t, 2050.0, points[0].TotalVolume)
+	assert.Equal(t, 102.5, points[0].AverageIntensity)
+	assert.Equal(t, 122.5, points[0].BestE1RM)
+	assert.Equal(t, 110.0, points[1].AverageIntensity)
+
+	from := time.Date(2025, 3, 8, 0, 0, 0, 0, time.UTC)
+	points, err = analyticsStore.GetExerciseProgression(ctx, ProgressionFilter{UserID: user.ID, Exercise: "Back Squat", From: &from, Formula: strength.FormulaEpley})
+	require.NoError(t, err)
+	assert.Len(t, points, 1)
+
+	_, err = analyticsStore.GetExerciseProgression(ctx, ProgressionFilter{UserID: user.ID, Exercise: "Back Squat", Bucket: "year"})
+	assert.ErrorIs(t, err, ErrInvalidBucket)
+}
+
+func TestPeriodBounds(t *testing.T) {
+	sunday := time.Date(2025, 3, 9, 22, 0, 0, 0, time.UTC)
+
+	start, end, err := PeriodBounds("week", sunday)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 3, 3, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2025, 3, 10, 0, 0, 0, 0, time.UTC), end)
+
+	start, end, err = PeriodBounds("month", sunday)
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC), start)
+	assert.Equal(t, time.Date(2025, 4, 1, 0, 0, 0, 0, time.UTC), end)
+
+	_, _, err = PeriodBounds("year", sunday)
+	assert.ErrorIs(t, err, ErrInvalidPeriod)
+}
+
+func TestTrainingSummary(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	workoutStore := NewPostgresWorkoutStore(db)
+	analyticsStore := NewPostgresAnalyticsStore(db)
+	user := createTestUser(t, db, "summarizer")
+
+	sessions := []struct {
+		date     time.Time
+		exercise string
+	}{
+		{time.Date(2025, 3, 4, 9, 0, 0, 0, time.UTC), "Deadlift"},
+		{time.Date(2025, 3, 11, 9, 0, 0, 0, time.UTC), "Deadlift"},
+		{time.Date(2025, 3, 13, 9, 0, 0, 0, time.UTC), "Bench Press"},
+	}
+	for _, session := range sessions {
+		workout, err := workoutStore.CreateWorkout(ctx, &Workout{
+			UserID:          user.ID,
+			Title:           "session",
+			DurationMinutes: 60,
+			CaloriesBurned:  300,
+			Entries: []WorkoutEntry{
+				{ExerciseName: session.exercise, Sets: ExpandSets(3, IntPointer(5), nil, FloatPointer(100)), OrderIndex: 1},
+			},
+		})
+		require.NoError(t, err)
+		_, err = db.Exec(`UPDATE workouts SET created_at = $1 WHERE id = $2`, session.date, workout.ID)
+		require.NoError(t, err)
+	}
+
+	summary, err := analyticsStore.GetTrainingSummary(ctx, user.ID, "week", time.Date(2025, 3, 12, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, summary.Current.Sessions)
+	assert.Equal(t, 120, summary.Current.DurationMinutes)
+	assert.Equal(t, 3000.0, summary.Current.VolumeLoad)
+	assert.Equal(t, 2, summary.Current.DistinctExercises)
+	assert.Equal(t, 1, summary.Previous.Sessions)
+	assert.Equal(t, 1.0, summary.Change["sessions"].Delta)
+	assert.Equal(t, 100.0, *summary.Change["volume_load"].Percent)
+}
@@ -0,0 +1,179 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// the actions of the admin endpoints, each one leaves an AuditEntry
+const (
+	AuditUsersList        = "users.list"
+	AuditUserView         = "users.view"
+	AuditUserDisable      = "users.disable"
+	AuditUserEnable       = "users.enable"
+	AuditUserSetRole      = "users.set_role"
+	AuditUserRevokeTokens = "users.revoke_tokens"
+	AuditWorkoutView      = "workouts.view"
+	AuditLogView          = "audit.list"
+)
+
+// AuditEntry one admin action. TargetType and TargetID are empty for
+// actions on no single record, like a search
+type AuditEntry struct {
+	ID         int64          `json:"id"`
+	ActorID    int            `json:"actor_id"`
+	Action     string         `json:"action"`
+	TargetType string         `json:"target_type,omitempty"`
+	TargetID   *int64         `json:"target_id,omitempty"`
+	Details    map[string]any `json:"details"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+const (
+	DefaultAuditListLimit = 50
+	MaxAuditListLimit     = 200
+)
+
+// AuditFilter newest entries first, zero values don't filter
+type AuditFilter struct {
+	ActorID    int
+	Action     string
+	TargetType string
+	TargetID   *int64
+	Limit      int
+	Cursor     string
+}
+
+type PostgresAuditStore struct {
+	db *sql.DB
+	queryTimeout
+}
+
+func NewPostgresAuditStore(db *sql.DB) *PostgresAuditStore {
+	return &PostgresAuditStore{db: db, queryTimeout: queryTimeout{timeout: DefaultQueryTimeout}}
+}
+
+type AuditStore interface {
+	RecordAudit(ctx context.Context, entry *AuditEntry) error
+	ListAudit(ctx context.Context, filter AuditFilter) ([]*AuditEntry, string, error)
+}
+
+// RecordAudit for actions that change nothing, like reads. changes write
+// their entry in their own transaction, see insertAuditEntry
+func (s *PostgresAuditStore) RecordAudit(ctx context.Context, entry *AuditEntry) error {
+	ctx, span := startSpan(ctx, "AuditStore.RecordAudit")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	return translateError(insertAuditEntry(ctx, s.db, entry))
+}
+
+// insertAuditEntry the store methods behind the admin actions that change
+// something take an entry and write it with q, their own transaction, so
+// the change never happens without its record
+func insertAuditEntry(ctx context.Context, q queryRower, entry *AuditEntry) error {
+	if entry.Details == nil {
+		entry.Details = map[string]any{}
+	}
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+
+	query := `
+  INSERT INTO audit_log (actor_id, action, target_type, target_id, details)
+  VALUES ($1, $2, $3, $4, $5)
+  RETURNING id, created_at
+  `
+
+	return q.QueryRowContext(ctx, query, entry.ActorID, entry.Action, entry.TargetType, entry.TargetID, details).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// ListAudit a page of the audit log, newest first, and the cursor of the
+// next page. the cursor is empty on the last page
+func (s *PostgresAuditStore) ListAudit(ctx context.Context, filter AuditFilter) ([]*AuditEntry, string, error) {
+	ctx, span := startSpan(ctx, "AuditStore.ListAudit")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultAuditListLimit
+	}
+	if filter.Limit > MaxAuditListLimit {
+		filter.Limit = MaxAuditListLimit
+	}
+
+	conditions := []string{"TRUE"}
+	args := []any{}
+	addCondition := func(format string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(format, fmt.Sprintf("$%d", len(args))))
+	}
+
+	if filter.ActorID != 0 {
+		addCondition("actor_id = %s", filter.ActorID)
+	}
+	if filter.Action != "" {
+		addCondition("action = %s", filter.Action)
+	}
+	if filter.TargetType != "" {
+		addCondition("target_type = %s", filter.TargetType)
+	}
+	if filter.TargetID != nil {
+		addCondition("target_id = %s", *filter.TargetID)
+	}
+	if filter.Cursor != "" {
+		beforeID, err := decodeIDCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		addCondition("id < %s", beforeID)
+	}
+
+	args = append(args, filter.Limit+1)
+	query := fmt.Sprintf(`
+  SELECT id, actor_id, action, target_type, target_id, details, created_at
+  FROM audit_log
+  WHERE %s
+  ORDER BY id DESC
+  LIMIT $%d
+  `, strings.Join(conditions, " AND "), len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	entries := []*AuditEntry{}
+	for rows.Next() {
+		entry := &AuditEntry{}
+		var details []byte
+		err = rows.Scan(&entry.ID, &entry.ActorID, &entry.Action, &entry.TargetType, &entry.TargetID, &details, &entry.CreatedAt)
+		if err != nil {
+			return nil, "", err
+		}
+		err = json.Unmarshal(details, &entry.Details)
+		if err != nil {
+			return nil, "", err
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(entries) > filter.Limit {
+		entries = entries[:filter.Limit]
+		nextCursor = encodeIDCursor(int(entries[len(entries)-1].ID))
+	}
+
+	return entries, nextCursor, nil
+}
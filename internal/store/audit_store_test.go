@@ -0,0 +1,47 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuditLog(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	auditStore := NewPostgresAuditStore(db)
+	admin := createTestUser(t, db, "auditor")
+
+	targetID := int64(42)
+	entries := []*AuditEntry{
+		{ActorID: admin.ID, Action: AuditUsersList, Details: map[string]any{"query": "search=bob"}},
+		{ActorID: admin.ID, Action: AuditUserDisable, TargetType: "user", TargetID: &targetID},
+		{ActorID: admin.ID, Action: AuditWorkoutView, TargetType: "workout", TargetID: &targetID},
+	}
+	for _, entry := range entries {
+		require.NoError(t, auditStore.RecordAudit(ctx, entry))
+		assert.NotZero(t, entry.ID)
+	}
+
+	page, next, err := auditStore.ListAudit(ctx, AuditFilter{Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	assert.Equal(t, AuditWorkoutView, page[0].Action, "newest first")
+	assert.Equal(t, AuditUserDisable, page[1].Action)
+
+	page, next, err = auditStore.ListAudit(ctx, AuditFilter{Limit: 2, Cursor: next})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, "search=bob", page[0].Details["query"])
+	assert.Nil(t, page[0].TargetID)
+	assert.Empty(t, next)
+
+	page, _, err = auditStore.ListAudit(ctx, AuditFilter{TargetType: "user", TargetID: &targetID})
+	require.NoError(t, err)
+	require.Len(t, page, 1)
+	assert.Equal(t, AuditUserDisable, page[0].Action)
+}
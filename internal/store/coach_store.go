@@ -0,0 +1,362 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/nickemma/internal/tokens"
+)
+
+// workoutReadableBy the SQL condition under which the user in the user
+// placeholder may read the workouts of the owner column: their own, or
+// those of an athlete that accepted them as coach, for as long as their
+// role lets them coach. every store query that reads another user's
+// workouts goes through it
+func workoutReadableBy(owner, user string) string {
+	return fmt.Sprintf(`(%[1]s = %[2]s OR EXISTS (
+    SELECT 1 FROM coach_athletes ca
+    INNER JOIN users cu ON cu.id = ca.coach_id
+    INNER JOIN role_permissions rp ON rp.role = cu.role AND rp.permission = '%[3]s'
+    WHERE ca.coach_id = %[2]s AND ca.athlete_id = %[1]s AND ca.accepted_at IS NOT NULL
+  ))`, owner, user, PermissionCoachAthletes)
+}
+
+// CoachAthlete one side of a coaching relationship as seen from the other,
+// UserID and Username are the athlete for a coach and the coach for an athlete
+type CoachAthlete struct {
+	UserID     int        `json:"user_id"`
+	Username   string     `json:"username"`
+	CreatedAt  time.Time  `json:"created_at"`
+	AcceptedAt *time.Time `json:"accepted_at"`
+}
+
+// PlannedWorkout a workout a coach put into the athlete's calendar. the
+// entries are in the template, which the athlete starts like any other
+type PlannedWorkout struct {
+	ID           int              `json:"id"`
+	AthleteID    int              `json:"athlete_id"`
+	CoachID      *int             `json:"coach_id"`
+	ScheduledFor time.Time        `json:"scheduled_for"`
+	Notes        string           `json:"notes"`
+	Template     *WorkoutTemplate `json:"template"`
+	CreatedAt    time.Time        `json:"created_at"`
+}
+
+var (
+	ErrAlreadyCoaching = newStoreError(ErrDuplicate, "you already coach this athlete")
+	ErrNotCoach        = newStoreError(ErrForbidden, "you are not a coach of this athlete")
+)
+
+type PostgresCoachStore struct {
+	db *sql.DB
+	queryTimeout
+}
+
+func NewPostgresCoachStore(db *sql.DB) *PostgresCoachStore {
+	return &PostgresCoachStore{db: db, queryTimeout: queryTimeout{timeout: DefaultQueryTimeout}}
+}
+
+type CoachStore interface {
+	CreateInvite(ctx context.Context, coachID, athleteID int) (*tokens.Token, error)
+	AcceptInvite(ctx context.Context, athleteID int, tokenPlainText string) (int, error)
+	ListAthletes(ctx context.Context, coachID int) ([]*CoachAthlete, error)
+	ListCoaches(ctx context.Context, athleteID int) ([]*CoachAthlete, error)
+	RemoveCoachAthlete(ctx context.Context, coachID, athleteID int) error
+	PlanWorkout(ctx context.Context, coachID int, plan *PlannedWorkout) error
+	ListPlannedWorkouts(ctx context.Context, athleteID, viewerID int, from, to *time.Time) ([]*PlannedWorkout, error)
+}
+
+// CreateInvite the coach-invite token for the athlete, a new invite
+// replaces a pending one. ErrAlreadyCoaching once the athlete accepted
+func (s *PostgresCoachStore) CreateInvite(ctx context.Context, coachID, athleteID int) (*tokens.Token, error) {
+	ctx, span := startSpan(ctx, "CoachStore.CreateInvite")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	token, err := tokens.GenerateToken(athleteID, tokens.CoachInviteTokenTTL, tokens.ScopeCoachInvite)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// the subquery sees the row as it was before the upsert, so it returns
+	// the hash of the invite being replaced. no row means already accepted
+	var previousHash []byte
+	query := `
+  INSERT INTO coach_athletes (coach_id, athlete_id, invite_hash)
+  VALUES ($1, $2, $3)
+  ON CONFLICT (coach_id, athlete_id) DO UPDATE SET invite_hash = EXCLUDED.invite_hash, created_at = NOW()
+  WHERE coach_athletes.accepted_at IS NULL
+  RETURNING (SELECT invite_hash FROM coach_athletes WHERE coach_id = $1 AND athlete_id = $2)
+  `
+	err = tx.QueryRowContext(ctx, query, coachID, athleteID, token.Hash).Scan(&previousHash)
+	if err == sql.ErrNoRows {
+		return nil, ErrAlreadyCoaching
+	}
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	// the token of the replaced invite can't be accepted anymore
+	if previousHash != nil {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE hash = $1`, previousHash)
+		if err != nil {
+			return nil, translateError(err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO tokens (hash, user_id, expiry, scope) VALUES ($1, $2, $3, $4)`,
+		token.Hash, token.UserID, token.Expiry, token.Scope)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return token, nil
+}
+
+// AcceptInvite turns the invite of the token into a relationship and
+// returns the coach. ErrNotFound when the token is unknown, expired or was
+// sent to someone else
+func (s *PostgresCoachStore) AcceptInvite(ctx context.Context, athleteID int, tokenPlainText string) (int, error) {
+	ctx, span := startSpan(ctx, "CoachStore.AcceptInvite")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	hash := tokens.Hash(tokenPlainText)
+	query := `
+  UPDATE coach_athletes ca
+  SET accepted_at = NOW(), invite_hash = NULL
+  FROM tokens t
+  WHERE t.hash = $1 AND t.scope = $2 AND t.expiry > $3 AND t.user_id = $4
+    AND ca.invite_hash = t.hash AND ca.athlete_id = t.user_id
+  RETURNING ca.coach_id
+  `
+
+	var coachID int
+	err = tx.QueryRowContext(ctx, query, hash, tokens.ScopeCoachInvite, time.Now(), athleteID).Scan(&coachID)
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE hash = $1`, hash)
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, translateError(err)
+	}
+
+	return coachID, nil
+}
+
+// ListAthletes the athletes of the coach, pending invites included
+func (s *PostgresCoachStore) ListAthletes(ctx context.Context, coachID int) ([]*CoachAthlete, error) {
+	ctx, span := startSpan(ctx, "CoachStore.ListAthletes")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+  SELECT u.id, u.username, ca.created_at, ca.accepted_at
+  FROM coach_athletes ca
+  INNER JOIN users u ON u.id = ca.athlete_id
+  WHERE ca.coach_id = $1
+  ORDER BY u.username
+  `
+	return s.listRelationships(ctx, query, coachID)
+}
+
+// ListCoaches the coaches of the athlete, pending invites included
+func (s *PostgresCoachStore) ListCoaches(ctx context.Context, athleteID int) ([]*CoachAthlete, error) {
+	ctx, span := startSpan(ctx, "CoachStore.ListCoaches")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+  SELECT u.id, u.username, ca.created_at, ca.accepted_at
+  FROM coach_athletes ca
+  INNER JOIN users u ON u.id = ca.coach_id
+  WHERE ca.athlete_id = $1
+  ORDER BY u.username
+  `
+	return s.listRelationships(ctx, query, athleteID)
+}
+
+func (s *PostgresCoachStore) listRelationships(ctx context.Context, query string, userID int) ([]*CoachAthlete, error) {
+	rows, err := s.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	relationships := []*CoachAthlete{}
+	for rows.Next() {
+		relationship := &CoachAthlete{}
+		err = rows.Scan(&relationship.UserID, &relationship.Username, &relationship.CreatedAt, &relationship.AcceptedAt)
+		if err != nil {
+			return nil, err
+		}
+		relationships = append(relationships, relationship)
+	}
+
+	return relationships, rows.Err()
+}
+
+// RemoveCoachAthlete ends the relationship or withdraws the invite, either
+// side may do it. ErrNotFound when there is none
+func (s *PostgresCoachStore) RemoveCoachAthlete(ctx context.Context, coachID, athleteID int) error {
+	ctx, span := startSpan(ctx, "CoachStore.RemoveCoachAthlete")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+  WITH removed AS (
+    DELETE FROM coach_athletes WHERE coach_id = $1 AND athlete_id = $2
+    RETURNING invite_hash
+  ), invite AS (
+    DELETE FROM tokens WHERE hash = (SELECT invite_hash FROM removed)
+  )
+  SELECT COUNT(*) FROM removed
+  `
+
+	var removed int
+	err := s.db.QueryRowContext(ctx, query, coachID, athleteID).Scan(&removed)
+	if err != nil {
+		return translateError(err)
+	}
+
+	if removed == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// PlanWorkout saves plan.Template as a template of the athlete and puts it
+// into their calendar. ErrNotCoach unless the athlete accepted the coach and
+// their role still lets them coach,
+// the relationship is locked until the plan is saved so a revoke can't
+// slip in between
+func (s *PostgresCoachStore) PlanWorkout(ctx context.Context, coachID int, plan *PlannedWorkout) error {
+	ctx, span := startSpan(ctx, "CoachStore.PlanWorkout")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var coaching bool
+	err = tx.QueryRowContext(ctx, `
+  SELECT EXISTS (
+    SELECT 1 FROM coach_athletes ca
+    INNER JOIN users cu ON cu.id = ca.coach_id
+    INNER JOIN role_permissions rp ON rp.role = cu.role AND rp.permission = $3
+    WHERE ca.coach_id = $1 AND ca.athlete_id = $2 AND ca.accepted_at IS NOT NULL
+    FOR SHARE OF ca
+  )`, coachID, plan.AthleteID, PermissionCoachAthletes).Scan(&coaching)
+	if err != nil {
+		return translateError(err)
+	}
+	if !coaching {
+		return ErrNotCoach
+	}
+
+	template := plan.Template
+	template.UserID = plan.AthleteID
+	err = tx.QueryRowContext(ctx, `
+  INSERT INTO workout_templates (user_id, title, description, duration_minutes)
+  VALUES ($1, $2, $3, $4)
+  RETURNING id, created_at
+  `, template.UserID, template.Title, template.Description, template.DurationMinutes).Scan(&template.ID, &template.CreatedAt)
+	if err != nil {
+		return translateError(err)
+	}
+
+	err = insertTemplateEntries(ctx, tx, template.ID, template.UserID, template.Entries)
+	if err != nil {
+		return translateError(err)
+	}
+
+	plan.CoachID = &coachID
+	err = tx.QueryRowContext(ctx, `
+  INSERT INTO planned_workouts (athlete_id, coach_id, template_id, scheduled_for, notes)
+  VALUES ($1, $2, $3, $4, $5)
+  RETURNING id, created_at
+  `, plan.AthleteID, coachID, template.ID, plan.ScheduledFor, plan.Notes).Scan(&plan.ID, &plan.CreatedAt)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return translateError(tx.Commit())
+}
+
+// ListPlannedWorkouts the calendar of the athlete between from and to, both
+// inclusive and optional, as seen by viewerID. the entries of the templates
+// are left out, the athlete gets them from the template
+func (s *PostgresCoachStore) ListPlannedWorkouts(ctx context.Context, athleteID, viewerID int, from, to *time.Time) ([]*PlannedWorkout, error) {
+	ctx, span := startSpan(ctx, "CoachStore.ListPlannedWorkouts")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+  SELECT p.id, p.athlete_id, p.coach_id, p.scheduled_for, p.notes, p.created_at,
+    t.id, t.user_id, t.title, COALESCE(t.description, ''), t.duration_minutes, t.created_at
+  FROM planned_workouts p
+  INNER JOIN workout_templates t ON t.id = p.template_id
+  WHERE p.athlete_id = $1 AND ` + workoutReadableBy("p.athlete_id", "$2") + `
+    AND ($3::date IS NULL OR p.scheduled_for >= $3::date)
+    AND ($4::date IS NULL OR p.scheduled_for <= $4::date)
+  ORDER BY p.scheduled_for, p.id
+  `
+
+	rows, err := s.db.QueryContext(ctx, query, athleteID, viewerID, from, to)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := []*PlannedWorkout{}
+	for rows.Next() {
+		plan := &PlannedWorkout{Template: &WorkoutTemplate{Entries: []WorkoutEntry{}}}
+		err = rows.Scan(
+			&plan.ID, &plan.AthleteID, &plan.CoachID, &plan.ScheduledFor, &plan.Notes, &plan.CreatedAt,
+			&plan.Template.ID, &plan.Template.UserID, &plan.Template.Title, &plan.Template.Description,
+			&plan.Template.DurationMinutes, &plan.Template.CreatedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, plan)
+	}
+
+	return plans, rows.Err()
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCoaching(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	coachStore := NewPostgresCoachStore(db)
+	workoutStore := NewPostgresWorkoutStore(db)
+	userStore := NewPostgresUserStore(db)
+	coach := createTestUser(t, db, "coach")
+	athlete := createTestUser(t, db, "athlete")
+	stranger := createTestUser(t, db, "stranger")
+	_, err := userStore.SetRole(ctx, coach.ID, RoleCoach, nil)
+	require.NoError(t, err)
+
+	workout, err := workoutStore.CreateWorkout(ctx, &Workout{
+		UserID:          athlete.ID,
+		Title:           "leg day",
+		DurationMinutes: 45,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "Squats", Sets: ExpandSets(3, IntPointer(5), nil, FloatPointer(100)), OrderIndex: 1},
+		},
+	})
+	require.NoError(t, err)
+	entryID := workout.Entries[0].ID
+	comment := func(userID int) error {
+		return workoutStore.AddEntryComment(ctx, int64(workout.ID), &EntryComment{WorkoutEntryID: entryID, Body: "go deeper"}, userID)
+	}
+
+	// a pending invite grants nothing
+	token, err := coachStore.CreateInvite(ctx, coach.ID, athlete.ID)
+	require.NoError(t, err)
+	_, err = workoutStore.GetWorkoutByID1(ctx, int64(workout.ID), coach.ID)
+	assert.ErrorIs(t, err, ErrWorkoutForbidden)
+
+	// the invite is for the athlete only, and a new one replaces the old
+	_, err = coachStore.AcceptInvite(ctx, stranger.ID, token.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+	replaced := token
+	token, err = coachStore.CreateInvite(ctx, coach.ID, athlete.ID)
+	require.NoError(t, err)
+	_, err = coachStore.AcceptInvite(ctx, athlete.ID, replaced.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	coachID, err := coachStore.AcceptInvite(ctx, athlete.ID, token.Plaintext)
+	require.NoError(t, err)
+	assert.Equal(t, coach.ID, coachID)
+	_, err = coachStore.CreateInvite(ctx, coach.ID, athlete.ID)
+	assert.ErrorIs(t, err, ErrAlreadyCoaching)
+
+	athletes, err := coachStore.ListAthletes(ctx, coach.ID)
+	require.NoError(t, err)
+	require.Len(t, athletes, 1)
+	assert.Equal(t, "athlete", athletes[0].Username)
+	assert.NotNil(t, athletes[0].AcceptedAt)
+
+	// the coach reads and comments, a stranger still can't
+	read, err := workoutStore.GetWorkoutByID1(ctx, int64(workout.ID), coach.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "leg day", read.Title)
+	_, err = workoutStore.GetWorkoutByID1(ctx, int64(workout.ID), stranger.ID)
+	assert.ErrorIs(t, err, ErrWorkoutForbidden)
+
+	workouts, _, err := workoutStore.ListWorkouts(ctx, WorkoutFilter{UserID: athlete.ID, ViewerID: coach.ID})
+	require.NoError(t, err)
+	assert.Len(t, workouts, 1)
+	workouts, _, err = workoutStore.ListWorkouts(ctx, WorkoutFilter{UserID: athlete.ID, ViewerID: stranger.ID})
+	require.NoError(t, err)
+	assert.Empty(t, workouts)
+
+	require.NoError(t, comment(coach.ID))
+	require.NoError(t, comment(athlete.ID))
+	assert.ErrorIs(t, comment(stranger.ID), ErrWorkoutForbidden)
+	comments, err := workoutStore.ListWorkoutComments(ctx, int64(workout.ID), athlete.ID)
+	require.NoError(t, err)
+	require.Len(t, comments, 2)
+	assert.Equal(t, "coach", comments[0].AuthorUsername)
+
+	// coaches can't change the athlete's workouts
+	read.Title = "rest day"
+	assert.ErrorIs(t, workoutStore.UpdateWorkout(ctx, read, coach.ID), ErrWorkoutForbidden)
+
+	plan := &PlannedWorkout{
+		AthleteID:    athlete.ID,
+		ScheduledFor: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		Notes:        "easy pace",
+		Template: &WorkoutTemplate{
+			Title:           "recovery run",
+			DurationMinutes: 30,
+			Entries:         []WorkoutEntry{{ExerciseName: "Plank", Sets: ExpandSets(3, nil, IntPointer(60), nil), OrderIndex: 1}},
+		},
+	}
+	require.NoError(t, coachStore.PlanWorkout(ctx, coach.ID, plan))
+	assert.Equal(t, athlete.ID, plan.Template.UserID)
+	assert.ErrorIs(t, coachStore.PlanWorkout(ctx, stranger.ID, &PlannedWorkout{AthleteID: athlete.ID, Template: &WorkoutTemplate{Title: "nope"}}), ErrNotCoach)
+
+	from := time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)
+	plans, err := coachStore.ListPlannedWorkouts(ctx, athlete.ID, athlete.ID, &from, nil)
+	require.NoError(t, err)
+	require.Len(t, plans, 1)
+	assert.Equal(t, "recovery run", plans[0].Template.Title)
+	plans, err = coachStore.ListPlannedWorkouts(ctx, athlete.ID, stranger.ID, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, plans)
+
+	// a coach demoted to a plain user keeps the relationship but not the access
+	_, err = userStore.SetRole(ctx, coach.ID, RoleUser, nil)
+	require.NoError(t, err)
+	_, err = workoutStore.GetWorkoutByID1(ctx, int64(workout.ID), coach.ID)
+	assert.ErrorIs(t, err, ErrWorkoutForbidden)
+	assert.ErrorIs(t, comment(coach.ID), ErrWorkoutForbidden)
+	workouts, _, err = workoutStore.ListWorkouts(ctx, WorkoutFilter{UserID: athlete.ID, ViewerID: coach.ID})
+	require.NoError(t, err)
+	assert.Empty(t, workouts)
+	plans, err = coachStore.ListPlannedWorkouts(ctx, athlete.ID, coach.ID, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, plans)
+	assert.ErrorIs(t, coachStore.PlanWorkout(ctx, coach.ID, &PlannedWorkout{AthleteID: athlete.ID, Template: &WorkoutTemplate{Title: "nope"}}), ErrNotCoach)
+
+	_, err = userStore.SetRole(ctx, coach.ID, RoleCoach, nil)
+	require.NoError(t, err)
+	_, err = workoutStore.GetWorkoutByID1(ctx, int64(workout.ID), coach.ID)
+	require.NoError(t, err)
+
+	// the athlete revokes, the coach loses access at once
+	require.NoError(t, coachStore.RemoveCoachAthlete(ctx, coach.ID, athlete.ID))
+	assert.ErrorIs(t, coachStore.RemoveCoachAthlete(ctx, coach.ID, athlete.ID), ErrNotFound)
+	_, err = workoutStore.GetWorkoutByID1(ctx, int64(workout.ID), coach.ID)
+	assert.ErrorIs(t, err, ErrWorkoutForbidden)
+	assert.ErrorIs(t, comment(coach.ID), ErrWorkoutForbidden)
+	plans, err = coachStore.ListPlannedWorkouts(ctx, athlete.ID, coach.ID, nil, nil)
+	require.NoError(t, err)
+	assert.Empty(t, plans)
+}
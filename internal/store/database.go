@@ -1,22 +1,51 @@
 package store
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/pressly/goose/v3"
 	"io/fs"
+	"time"
 )
 
-func Open() (*sql.DB, error) {
-	conn, err := sql.Open("pgx", "host=localhost user=root password=postgres dbname=postgres port=5432 sslmode=disable")
+const (
+	// DefaultQueryTimeout bounds every store call unless the store is told otherwise
+	DefaultQueryTimeout = 5 * time.Second
+	// AnalyticsQueryTimeout the analytics aggregates scan whole histories, they get a bit more room
+	AnalyticsQueryTimeout = 15 * time.Second
+)
 
-	if err != nil {
-		return nil, fmt.Errorf("error opening database: %w", err)
+// queryTimeout embedded in every postgres store so each one can be given
+// its own deadline, a slow store can't hold pool connections forever
+type queryTimeout struct {
+	timeout time.Duration
+}
+
+// SetQueryTimeout zero or a negative duration disables the store deadline,
+// the caller's context still applies
+func (q *queryTimeout) SetQueryTimeout(d time.Duration) {
+	q.timeout = d
+}
+
+func (q *queryTimeout) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if q.timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, q.timeout)
+}
 
-	fmt.Println("Connected to database")
-	return conn, nil
+// Open the pool for dsn with query tracing. nothing connects until the
+// first query, the migrations right after startup are what reach the database
+func Open(dsn string) (*sql.DB, error) {
+	config, err := pgx.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing database dsn: %w", err)
+	}
+	config.Tracer = queryTracer{}
+	return stdlib.OpenDB(*config), nil
 }
 
 func MigrateFs(db *sql.DB, migrationFs fs.FS, dir string) error {
@@ -40,3 +69,14 @@ func Migrate(db *sql.DB, dir string) error {
 	}
 	return nil
 }
+
+// SchemaVersion the version the database is migrated to and the newest
+// migration in migrationFs, they differ while migrations are pending
+func SchemaVersion(ctx context.Context, db *sql.DB, migrationFs fs.FS) (current, latest int64, err error) {
+	// the provider is not closed, that would close db
+	provider, err := goose.NewProvider(goose.DialectPostgres, db, migrationFs)
+	if err != nil {
+		return 0, 0, fmt.Errorf("error loading migrations: %w", err)
+	}
+	return provider.GetVersions(ctx)
+}
@@ -0,0 +1,58 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// the kinds of errors every store returns, handlers only need to check these
+var (
+	ErrNotFound  = errors.New("record not found")
+	ErrDuplicate = errors.New("record already exists")
+	ErrConflict  = errors.New("record was changed by another request")
+	ErrForbidden = errors.New("record belongs to another user")
+)
+
+// storeError a more specific error that still matches its kind with errors.Is
+type storeError struct {
+	kind    error
+	message string
+}
+
+func newStoreError(kind error, message string) error {
+	return &storeError{kind: kind, message: message}
+}
+
+func (e *storeError) Error() string {
+	return e.message
+}
+
+func (e *storeError) Unwrap() error {
+	return e.kind
+}
+
+// translateError maps database errors to the store errors. the original
+// error stays wrapped so it can still be logged or turned into field errors
+func translateError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch pgErr.Code {
+		case "23505": // unique_violation
+			return fmt.Errorf("%w: %w", ErrDuplicate, err)
+		case "23503", "40001", "40P01": // foreign_key_violation, serialization_failure, deadlock_detected
+			return fmt.Errorf("%w: %w", ErrConflict, err)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,29 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTranslateError(t *testing.T) {
+	assert.NoError(t, translateError(nil))
+	assert.ErrorIs(t, translateError(sql.ErrNoRows), ErrNotFound)
+
+	unique := &pgconn.PgError{Code: "23505", ConstraintName: "idx_exercises_owner_name"}
+	err := translateError(unique)
+	assert.ErrorIs(t, err, ErrDuplicate)
+	var pgErr *pgconn.PgError
+	assert.True(t, errors.As(err, &pgErr), "the pgx error stays wrapped")
+
+	assert.ErrorIs(t, translateError(&pgconn.PgError{Code: "40001"}), ErrConflict)
+
+	other := errors.New("connection reset")
+	assert.Equal(t, other, translateError(other))
+
+	assert.ErrorIs(t, ErrWorkoutForbidden, ErrForbidden)
+	assert.ErrorIs(t, ErrDuplicateEmail, ErrDuplicate)
+}
@@ -0,0 +1,303 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+)
+
+// Exercise a canonical exercise from the catalog. UserID is nil for the shared
+// catalog and set for exercises a user added for themselves
+type Exercise struct {
+	ID               int       `json:"id"`
+	UserID           *int      `json:"user_id"`
+	Name             string    `json:"name"`
+	Aliases          []string  `json:"aliases"`
+	PrimaryMuscles   []string  `json:"primary_muscles"`
+	SecondaryMuscles []string  `json:"secondary_muscles"`
+	Equipment        string    `json:"equipment"`
+	MovementPattern  string    `json:"movement_pattern"`
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// ExerciseFilter for searching the catalog, empty fields are ignored
+type ExerciseFilter struct {
+	UserID          int
+	Query           string // matches the name or any alias
+	Muscle          string // primary or secondary
+	Equipment       string
+	MovementPattern string
+	Limit           int
+}
+
+var ErrExerciseForbidden = newStoreError(ErrForbidden, "exercise can't be modified by user")
+var ErrUnknownExercise = errors.New("unknown exercise")
+
+type PostgresExerciseStore struct {
+	db *sql.DB
+	queryTimeout
+}
+
+func NewPostgresExerciseStore(db *sql.DB) *PostgresExerciseStore {
+	return &PostgresExerciseStore{db: db, queryTimeout: queryTimeout{timeout: DefaultQueryTimeout}}
+}
+
+type ExerciseStore interface {
+	SearchExercises(ctx context.Context, filter ExerciseFilter) ([]*Exercise, error)
+	GetExerciseByID(ctx context.Context, id int64, userID int) (*Exercise, error)
+	CreateExercise(ctx context.Context, exercise *Exercise) (*Exercise, error)
+	UpdateExercise(ctx context.Context, exercise *Exercise, userID int) error
+	DeleteExercise(ctx context.Context, id int64, userID int) error
+}
+
+const exerciseColumns = `id, user_id, name, aliases, primary_muscles, secondary_muscles, equipment, movement_pattern, created_at`
+
+// scanExercise text[] columns need pgtype to scan through database/sql
+func scanExercise(row interface{ Scan(...any) error }) (*Exercise, error) {
+	exercise := &Exercise{}
+	m := pgtype.NewMap()
+	var userID sql.NullInt64
+	err := row.Scan(
+		&exercise.ID,
+		&userID,
+		&exercise.Name,
+		m.SQLScanner(&exercise.Aliases),
+		m.SQLScanner(&exercise.PrimaryMuscles),
+		m.SQLScanner(&exercise.SecondaryMuscles),
+		&exercise.Equipment,
+		&exercise.MovementPattern,
+		&exercise.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if userID.Valid {
+		id := int(userID.Int64)
+		exercise.UserID = &id
+	}
+	return exercise, nil
+}
+
+// SearchExercises returns the shared catalog plus the user's own exercises
+func (pg *PostgresExerciseStore) SearchExercises(ctx context.Context, filter ExerciseFilter) ([]*Exercise, error) {
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	conditions := []string{"(user_id IS NULL OR user_id = $1)"}
+	args := []any{filter.UserID}
+
+	if filter.Query != "" {
+		args = append(args, "%"+escapeLike(strings.ToLower(filter.Query))+"%")
+		conditions = append(conditions, fmt.Sprintf(
+			"(LOWER(name) LIKE $%d OR EXISTS (SELECT 1 FROM unnest(aliases) a WHERE LOWER(a) LIKE $%d))", len(args), len(args)))
+	}
+	if filter.Muscle != "" {
+		args = append(args, strings.ToLower(filter.Muscle))
+		conditions = append(conditions, fmt.Sprintf("($%d = ANY(primary_muscles) OR $%d = ANY(secondary_muscles))", len(args), len(args)))
+	}
+	if filter.Equipment != "" {
+		args = append(args, strings.ToLower(filter.Equipment))
+		conditions = append(conditions, fmt.Sprintf("equipment = $%d", len(args)))
+	}
+	if filter.MovementPattern != "" {
+		args = append(args, strings.ToLower(filter.MovementPattern))
+		conditions = append(conditions, fmt.Sprintf("movement_pattern = $%d", len(args)))
+	}
+
+	if filter.Limit <= 0 || filter.Limit > 100 {
+		filter.Limit = 50
+	}
+	args = append(args, filter.Limit)
+
+	query := fmt.Sprintf(`
+SELECT %s
+FROM exercises
+WHERE %s
+ORDER BY name, id
+LIMIT $%d
+`, exerciseColumns, strings.Join(conditions, " AND "), len(args))
+
+	rows, err := pg.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	exercises := []*Exercise{}
+	for rows.Next() {
+		exercise, err := scanExercise(rows)
+		if err != nil {
+			return nil, err
+		}
+		exercises = append(exercises, exercise)
+	}
+
+	return exercises, rows.Err()
+}
+
+// GetExerciseByID returns ErrNotFound when the exercise does not exist or is
+// another user's custom exercise
+func (pg *PostgresExerciseStore) GetExerciseByID(ctx context.Context, id int64, userID int) (*Exercise, error) {
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	query := fmt.Sprintf(`
+SELECT %s
+FROM exercises
+WHERE id = $1 AND (user_id IS NULL OR user_id = $2)
+`, exerciseColumns)
+
+	exercise, err := scanExercise(pg.db.QueryRowContext(ctx, query, id, userID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return exercise, nil
+}
+
+// CreateExercise adds a custom exercise for exercise.UserID
+func (pg *PostgresExerciseStore) CreateExercise(ctx context.Context, exercise *Exercise) (*Exercise, error) {
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	normalizeExercise(exercise)
+
+	query := `
+INSERT INTO exercises (user_id, name, aliases, primary_muscles, secondary_muscles, equipment, movement_pattern)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, created_at
+`
+	err := pg.db.QueryRowContext(ctx, query, exercise.UserID, exercise.Name, exercise.Aliases, exercise.PrimaryMuscles,
+		exercise.SecondaryMuscles, exercise.Equipment, exercise.MovementPattern).Scan(&exercise.ID, &exercise.CreatedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	return exercise, nil
+}
+
+// checkExerciseOwner the shared catalog is only changed through migrations
+func checkExerciseOwner(ctx context.Context, q queryRower, id int64, userID int) error {
+	var ownerID sql.NullInt64
+	err := q.QueryRowContext(ctx, `SELECT user_id FROM exercises WHERE id = $1`, id).Scan(&ownerID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	if !ownerID.Valid || int(ownerID.Int64) != userID {
+		return ErrExerciseForbidden
+	}
+	return nil
+}
+
+func (pg *PostgresExerciseStore) UpdateExercise(ctx context.Context, exercise *Exercise, userID int) error {
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	err := checkExerciseOwner(ctx, pg.db, int64(exercise.ID), userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	normalizeExercise(exercise)
+	query := `
+UPDATE exercises
+SET name = $1, aliases = $2, primary_muscles = $3, secondary_muscles = $4, equipment = $5, movement_pattern = $6, updated_at = CURRENT_TIMESTAMP
+WHERE id = $7 AND user_id = $8
+`
+	_, err = pg.db.ExecContext(ctx, query, exercise.Name, exercise.Aliases, exercise.PrimaryMuscles, exercise.SecondaryMuscles,
+		exercise.Equipment, exercise.MovementPattern, exercise.ID, userID)
+	return translateError(err)
+}
+
+// DeleteExercise logged entries keep their exercise_name, exercise_id is set to NULL
+func (pg *PostgresExerciseStore) DeleteExercise(ctx context.Context, id int64, userID int) error {
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	err := checkExerciseOwner(ctx, pg.db, id, userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	_, err = pg.db.ExecContext(ctx, `DELETE FROM exercises WHERE id = $1 AND user_id = $2`, id, userID)
+	return translateError(err)
+}
+
+// normalizeExercise muscles, equipment and patterns are matched lowercase
+func normalizeExercise(exercise *Exercise) {
+	lower := func(values []string) []string {
+		out := make([]string, 0, len(values))
+		for _, v := range values {
+			if v = strings.ToLower(strings.TrimSpace(v)); v != "" {
+				out = append(out, v)
+			}
+		}
+		return out
+	}
+
+	exercise.Name = strings.TrimSpace(exercise.Name)
+	if exercise.Aliases == nil {
+		exercise.Aliases = []string{}
+	}
+	exercise.PrimaryMuscles = lower(exercise.PrimaryMuscles)
+	exercise.SecondaryMuscles = lower(exercise.SecondaryMuscles)
+	exercise.Equipment = strings.ToLower(exercise.Equipment)
+	if exercise.Equipment == "" {
+		exercise.Equipment = "none"
+	}
+	exercise.MovementPattern = strings.ToLower(exercise.MovementPattern)
+	if exercise.MovementPattern == "" {
+		exercise.MovementPattern = "other"
+	}
+}
+
+// resolveExercise links the entry to the catalog. with an exercise_id the
+// exercise must be visible to the user, otherwise the name is matched against
+// the catalog names and aliases. a match renames the entry to the canonical
+// name so "bench", "BB Bench" and "Bench Press" end up the same exercise,
+// unmatched names are kept as free text
+func resolveExercise(ctx context.Context, q queryRower, userID int, entry *WorkoutEntry) error {
+	var id int
+	var name string
+
+	if entry.ExerciseID != nil {
+		err := q.QueryRowContext(ctx, `
+SELECT id, name FROM exercises
+WHERE id = $1 AND (user_id IS NULL OR user_id = $2)
+`, *entry.ExerciseID, userID).Scan(&id, &name)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: %d", ErrUnknownExercise, *entry.ExerciseID)
+		}
+		if err != nil {
+			return err
+		}
+		entry.ExerciseName = name
+		return nil
+	}
+
+	// the user's own exercises win over the shared catalog
+	err := q.QueryRowContext(ctx, `
+SELECT id, name FROM exercises
+WHERE (user_id IS NULL OR user_id = $1)
+  AND (LOWER(name) = LOWER($2) OR EXISTS (SELECT 1 FROM unnest(aliases) a WHERE LOWER(a) = LOWER($2)))
+ORDER BY user_id NULLS LAST, LOWER(name) = LOWER($2) DESC
+LIMIT 1
+`, userID, strings.TrimSpace(entry.ExerciseName)).Scan(&id, &name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	entry.ExerciseID = &id
+	entry.ExerciseName = name
+	return nil
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExerciseCatalog(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	exerciseStore := NewPostgresExerciseStore(db)
+	user := createTestUser(t, db, "cataloger")
+	other := createTestUser(t, db, "stranger")
+
+	found, err := exerciseStore.SearchExercises(ctx, ExerciseFilter{UserID: user.ID, Query: "bb bench"})
+	require.NoError(t, err)
+	require.NotEmpty(t, found)
+	assert.Equal(t, "Bench Press", found[0].Name)
+
+	custom, err := exerciseStore.CreateExercise(ctx, &Exercise{
+		UserID:         &user.ID,
+		Name:           "Landmine Press",
+		Aliases:        []string{"landmine"},
+		PrimaryMuscles: []string{"Shoulders"},
+		Equipment:      "barbell",
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"shoulders"}, custom.PrimaryMuscles)
+
+	found, err = exerciseStore.SearchExercises(ctx, ExerciseFilter{UserID: other.ID, Query: "landmine"})
+	require.NoError(t, err)
+	assert.Empty(t, found)
+
+	assert.ErrorIs(t, exerciseStore.DeleteExercise(ctx, int64(custom.ID), other.ID), ErrExerciseForbidden)
+	assert.ErrorIs(t, exerciseStore.DeleteExercise(ctx, int64(searchFirstExercise(t, exerciseStore, user.ID, "Bench Press").ID), user.ID), ErrExerciseForbidden)
+
+	workoutStore := NewPostgresWorkoutStore(db)
+	workout, err := workoutStore.CreateWorkout(ctx, &Workout{
+		UserID:          user.ID,
+		Title:           "press day",
+		DurationMinutes: 40,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "bench Press", Sets: ExpandSets(3, IntPointer(5), nil, FloatPointer(80)), OrderIndex: 1},
+			{ExerciseName: "landmine", Sets: ExpandSets(3, IntPointer(10), nil, FloatPointer(20)), OrderIndex: 2},
+			{ExerciseName: "Zercher carry", Sets: ExpandSets(1, nil, IntPointer(30), nil), OrderIndex: 3},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Bench Press", workout.Entries[0].ExerciseName)
+	assert.Equal(t, custom.ID, *workout.Entries[1].ExerciseID)
+	assert.Nil(t, workout.Entries[2].ExerciseID)
+
+	_, err = workoutStore.CreateWorkout(ctx, &Workout{
+		UserID:          other.ID,
+		Title:           "sneaky",
+		DurationMinutes: 10,
+		Entries: []WorkoutEntry{
+			{ExerciseID: &custom.ID, Sets: ExpandSets(1, IntPointer(1), nil, nil), OrderIndex: 1},
+		},
+	})
+	assert.ErrorIs(t, err, ErrUnknownExercise)
+}
+
+func searchFirstExercise(t *testing.T, exerciseStore *PostgresExerciseStore, userID int, query string) *Exercise {
+	ctx := context.Background()
+	found, err := exerciseStore.SearchExercises(ctx, ExerciseFilter{UserID: userID, Query: query})
+	require.NoError(t, err)
+	require.NotEmpty(t, found)
+	return found[0]
+}
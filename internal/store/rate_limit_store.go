@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// rateLimitRetention buckets untouched for this long are deleted, a missing
+// bucket starts full again
+const rateLimitRetention = time.Hour
+
+// PostgresRateLimitStore token buckets in the rate_limits table, so every
+// instance of the api draws from the same bucket
+type PostgresRateLimitStore struct {
+	db *sql.DB
+	queryTimeout
+
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+func NewPostgresRateLimitStore(db *sql.DB) *PostgresRateLimitStore {
+	return &PostgresRateLimitStore{db: db, queryTimeout: queryTimeout{timeout: DefaultQueryTimeout}}
+}
+
+// Take refills the bucket for the time since it was last touched and takes
+// a token when one is available, in a single statement so concurrent
+// requests on other instances can't both take the last token
+func (pg *PostgresRateLimitStore) Take(ctx context.Context, key string, rate float64, burst int) (bool, time.Duration, error) {
+	ctx, span := startSpan(ctx, "RateLimitStore.Take")
+	defer span.End()
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	pg.sweep(ctx)
+
+	query := `
+  INSERT INTO rate_limits AS rl (key, tokens, allowed, updated_at)
+  VALUES ($1, $2::float8 - 1, TRUE, NOW())
+  ON CONFLICT (key) DO UPDATE SET
+    allowed = LEAST($2::float8, rl.tokens + EXTRACT(EPOCH FROM NOW() - rl.updated_at)::float8 * $3::float8) >= 1,
+    tokens = LEAST($2::float8, rl.tokens + EXTRACT(EPOCH FROM NOW() - rl.updated_at)::float8 * $3::float8)
+      - CASE WHEN LEAST($2::float8, rl.tokens + EXTRACT(EPOCH FROM NOW() - rl.updated_at)::float8 * $3::float8) >= 1 THEN 1 ELSE 0 END,
+    updated_at = NOW()
+  RETURNING tokens, allowed
+  `
+
+	var tokens float64
+	var allowed bool
+	err := pg.db.QueryRowContext(ctx, query, key, burst, rate).Scan(&tokens, &allowed)
+	if err != nil {
+		return false, 0, translateError(err)
+	}
+
+	if !allowed {
+		return false, time.Duration((1 - tokens) / rate * float64(time.Second)), nil
+	}
+	return true, 0, nil
+}
+
+// sweep deletes stale buckets at most once per minute and instance, a
+// failure is ignored, the next sweep catches up
+func (pg *PostgresRateLimitStore) sweep(ctx context.Context) {
+	pg.mu.Lock()
+	if time.Since(pg.lastSweep) < time.Minute {
+		pg.mu.Unlock()
+		return
+	}
+	pg.lastSweep = time.Now()
+	pg.mu.Unlock()
+
+	_, _ = pg.db.ExecContext(ctx, `DELETE FROM rate_limits WHERE updated_at < $1`, time.Now().Add(-rateLimitRetention))
+}
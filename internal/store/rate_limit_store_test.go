@@ -0,0 +1,33 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRateLimitStore(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	limits := NewPostgresRateLimitStore(db)
+
+	// one token per minute, two at once
+	for i := 0; i < 2; i++ {
+		allowed, _, err := limits.Take(ctx, "login-ip:10.0.0.1", 1.0/60, 2)
+		require.NoError(t, err)
+		assert.True(t, allowed, "attempt %d", i)
+	}
+
+	allowed, retryAfter, err := limits.Take(ctx, "login-ip:10.0.0.1", 1.0/60, 2)
+	require.NoError(t, err)
+	assert.False(t, allowed)
+	assert.Greater(t, retryAfter.Seconds(), 55.0)
+
+	allowed, _, err = limits.Take(ctx, "login-ip:10.0.0.2", 1.0/60, 2)
+	require.NoError(t, err)
+	assert.True(t, allowed)
+}
@@ -0,0 +1,345 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nickemma/internal/strength"
+)
+
+const (
+	RecordMaxWeight   = "max_weight"
+	RecordMaxReps     = "max_reps" // most reps at a given weight
+	RecordMaxE1RM     = "max_e1rm"
+	RecordMaxDuration = "max_duration"
+)
+
+// PersonalRecord a best performance for an exercise. a new row is written
+// every time a record is beaten, so older rows are the record history
+type PersonalRecord struct {
+	ID              int       `json:"id"`
+	UserID          int       `json:"user_id"`
+	WorkoutID       int       `json:"workout_id"`
+	ExerciseID      *int      `json:"exercise_id"`
+	ExerciseName    string    `json:"exercise_name"`
+	RecordType      string    `json:"record_type"`
+	Value           float64   `json:"value"`
+	Reps            *int      `json:"reps"`
+	Weight          *float64  `json:"weight"`
+	DurationSeconds *int      `json:"duration_seconds"`
+	AchievedAt      time.Time `json:"achieved_at"`
+}
+
+type PostgresRecordStore struct {
+	db *sql.DB
+	queryTimeout
+}
+
+func NewPostgresRecordStore(db *sql.DB) *PostgresRecordStore {
+	return &PostgresRecordStore{db: db, queryTimeout: queryTimeout{timeout: DefaultQueryTimeout}}
+}
+
+type RecordStore interface {
+	GetCurrentRecords(ctx context.Context, userID int) ([]*PersonalRecord, error)
+	GetRecordHistory(ctx context.Context, userID int, exerciseID int64) ([]*PersonalRecord, error)
+}
+
+const recordColumns = `id, user_id, workout_id, exercise_id, exercise_name, record_type, value, reps, weight, duration_seconds, achieved_at`
+
+func scanRecords(rows *sql.Rows) ([]*PersonalRecord, error) {
+	defer rows.Close()
+
+	records := []*PersonalRecord{}
+	for rows.Next() {
+		record := &PersonalRecord{}
+		err := rows.Scan(
+			&record.ID,
+			&record.UserID,
+			&record.WorkoutID,
+			&record.ExerciseID,
+			&record.ExerciseName,
+			&record.RecordType,
+			&record.Value,
+			&record.Reps,
+			&record.Weight,
+			&record.DurationSeconds,
+			&record.AchievedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// GetCurrentRecords the standing record of every exercise and record type,
+// max_reps has one record per weight
+func (pg *PostgresRecordStore) GetCurrentRecords(ctx context.Context, userID int) ([]*PersonalRecord, error) {
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+SELECT ` + recordColumns + `
+FROM (
+    SELECT DISTINCT ON (COALESCE(exercise_id::text, LOWER(exercise_name)), record_type, weight) *
+    FROM personal_records
+    WHERE user_id = $1
+    ORDER BY COALESCE(exercise_id::text, LOWER(exercise_name)), record_type, weight, value DESC, achieved_at
+) current
+ORDER BY exercise_name, record_type, weight
+`
+	rows, err := pg.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecords(rows)
+}
+
+// GetRecordHistory every record the user set on the exercise, oldest first
+func (pg *PostgresRecordStore) GetRecordHistory(ctx context.Context, userID int, exerciseID int64) ([]*PersonalRecord, error) {
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+SELECT ` + recordColumns + `
+FROM personal_records
+WHERE user_id = $1 AND exercise_id = $2
+ORDER BY achieved_at, id
+`
+	rows, err := pg.db.QueryContext(ctx, query, userID, exerciseID)
+	if err != nil {
+		return nil, err
+	}
+	return scanRecords(rows)
+}
+
+// recordCandidates the best set of the entry for every record type,
+// warmup sets never count
+func recordCandidates(entry *WorkoutEntry) []PersonalRecord {
+	var best = map[string]*PersonalRecord{}
+	repsAtWeight := map[float64]*PersonalRecord{}
+
+	for i := range entry.Sets {
+		set := &entry.Sets[i]
+		if set.SetType == SetTypeWarmup {
+			continue
+		}
+
+		reps, weight := intOrZero(set.Reps), floatOrZero(set.Weight)
+		if reps > 0 && weight > 0 {
+			candidate := PersonalRecord{Reps: set.Reps, Weight: set.Weight}
+
+			if current, ok := best[RecordMaxWeight]; !ok || weight > current.Value || (weight == current.Value && reps > *current.Reps) {
+				record := candidate
+				record.RecordType, record.Value = RecordMaxWeight, weight
+				best[RecordMaxWeight] = &record
+			}
+
+			e1rm := roundRecord(strength.Epley(weight, reps))
+			if current, ok := best[RecordMaxE1RM]; !ok || e1rm > current.Value {
+				record := candidate
+				record.RecordType, record.Value = RecordMaxE1RM, e1rm
+				best[RecordMaxE1RM] = &record
+			}
+
+			if current, ok := repsAtWeight[weight]; !ok || float64(reps) > current.Value {
+				record := candidate
+				record.RecordType, record.Value = RecordMaxReps, float64(reps)
+				repsAtWeight[weight] = &record
+			}
+		}
+
+		if duration := intOrZero(set.DurationSeconds); duration > 0 {
+			if current, ok := best[RecordMaxDuration]; !ok || float64(duration) > current.Value {
+				best[RecordMaxDuration] = &PersonalRecord{RecordType: RecordMaxDuration, Value: float64(duration), DurationSeconds: set.DurationSeconds, Weight: set.Weight}
+			}
+		}
+	}
+
+	candidates := []PersonalRecord{}
+	for _, recordType := range []string{RecordMaxWeight, RecordMaxE1RM, RecordMaxDuration} {
+		if record, ok := best[recordType]; ok {
+			candidates = append(candidates, *record)
+		}
+	}
+	weights := make([]float64, 0, len(repsAtWeight))
+	for weight := range repsAtWeight {
+		weights = append(weights, weight)
+	}
+	sort.Float64s(weights)
+	for _, weight := range weights {
+		candidates = append(candidates, *repsAtWeight[weight])
+	}
+
+	for i := range candidates {
+		candidates[i].ExerciseID = entry.ExerciseID
+		candidates[i].ExerciseName = entry.ExerciseName
+	}
+	return candidates
+}
+
+// roundRecord values are stored with 2 decimals, compare them the same way
+func roundRecord(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// detectPersonalRecords compares every entry of the workout against the
+// user's records and writes the ones it beats, runs inside the workout
+// transaction so records never point at a workout that failed to save
+func detectPersonalRecords(ctx context.Context, tx *sql.Tx, workout *Workout) ([]PersonalRecord, error) {
+	var achievedAt time.Time
+	err := tx.QueryRowContext(ctx, `SELECT created_at FROM workouts WHERE id = $1`, workout.ID).Scan(&achievedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	bestQuery := `
+SELECT COALESCE(MAX(value), 0)
+FROM personal_records
+WHERE user_id = $1 AND record_type = $2
+  AND exercise_id IS NOT DISTINCT FROM $3::bigint
+  AND ($3::bigint IS NOT NULL OR LOWER(exercise_name) = LOWER($4))
+  AND ($5::numeric IS NULL OR weight = $5::numeric)
+`
+	insertQuery := `
+INSERT INTO personal_records (user_id, workout_id, exercise_id, exercise_name, record_type, value, reps, weight, duration_seconds, achieved_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+RETURNING id
+`
+
+	newRecords := []PersonalRecord{}
+	for i := range workout.Entries {
+		for _, candidate := range recordCandidates(&workout.Entries[i]) {
+			var weightFilter *float64
+			if candidate.RecordType == RecordMaxReps {
+				weightFilter = candidate.Weight
+			}
+
+			var current float64
+			err = tx.QueryRowContext(ctx, bestQuery, workout.UserID, candidate.RecordType, candidate.ExerciseID, candidate.ExerciseName, weightFilter).Scan(&current)
+			if err != nil {
+				return nil, err
+			}
+			if candidate.Value <= current {
+				continue
+			}
+
+			candidate.UserID = workout.UserID
+			candidate.WorkoutID = workout.ID
+			candidate.AchievedAt = achievedAt
+			err = tx.QueryRowContext(ctx, insertQuery, candidate.UserID, candidate.WorkoutID, candidate.ExerciseID, candidate.ExerciseName,
+				candidate.RecordType, candidate.Value, candidate.Reps, candidate.Weight, candidate.DurationSeconds, candidate.AchievedAt).Scan(&candidate.ID)
+			if err != nil {
+				return nil, err
+			}
+			newRecords = append(newRecords, candidate)
+		}
+	}
+
+	return newRecords, nil
+}
+
+// recordKey identifies the exercise of an entry the way the records queries
+// do, by catalog id and otherwise by name
+func recordKey(entry *WorkoutEntry) string {
+	if entry.ExerciseID != nil {
+		return strconv.Itoa(*entry.ExerciseID)
+	}
+	return strings.ToLower(entry.ExerciseName)
+}
+
+// workoutRecordKeys the exercises the workout holds records on
+func workoutRecordKeys(ctx context.Context, tx *sql.Tx, workoutID int) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, `
+SELECT DISTINCT COALESCE(exercise_id::text, LOWER(exercise_name))
+FROM personal_records
+WHERE workout_id = $1
+`, workoutID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		err = rows.Scan(&key)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// recomputeRecords replays record detection on the exercises of keys over
+// the user's workouts created since, oldest first. editing or deleting a
+// workout changes what the later workouts were measured against, so their
+// records are rebuilt rather than kept. returns the new records by workout id
+func recomputeRecords(ctx context.Context, tx *sql.Tx, userID int, since time.Time, keys []string) (map[int][]PersonalRecord, error) {
+	byWorkout := map[int][]PersonalRecord{}
+	if len(keys) == 0 {
+		return byWorkout, nil
+	}
+
+	_, err := tx.ExecContext(ctx, `
+DELETE FROM personal_records
+WHERE user_id = $1 AND achieved_at >= $2
+  AND COALESCE(exercise_id::text, LOWER(exercise_name)) = ANY($3)
+`, userID, since, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := tx.QueryContext(ctx, `
+SELECT w.id, e.id, e.exercise_id, e.exercise_name, e.sets, e.reps, e.duration_seconds, e.weight
+FROM workouts w
+INNER JOIN workout_entries e ON e.workout_id = w.id
+WHERE w.user_id = $1 AND w.created_at >= $2
+  AND COALESCE(e.exercise_id::text, LOWER(e.exercise_name)) = ANY($3)
+ORDER BY w.created_at, w.id, e.order_index
+`, userID, since, keys)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var workouts []*Workout
+	for rows.Next() {
+		var workoutID int
+		var entry WorkoutEntry
+		err = rows.Scan(&workoutID, &entry.ID, &entry.ExerciseID, &entry.ExerciseName, &entry.setCount, &entry.Reps, &entry.DurationSeconds, &entry.Weight)
+		if err != nil {
+			return nil, err
+		}
+		if len(workouts) == 0 || workouts[len(workouts)-1].ID != workoutID {
+			workouts = append(workouts, &Workout{ID: workoutID, UserID: userID})
+		}
+		last := workouts[len(workouts)-1]
+		last.Entries = append(last.Entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	err = attachSets(ctx, tx, workoutSetsTable, workoutEntryPointers(workouts...))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, workout := range workouts {
+		byWorkout[workout.ID], err = detectPersonalRecords(ctx, tx, workout)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return byWorkout, nil
+}
@@ -0,0 +1,147 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordCandidates(t *testing.T) {
+	entry := &WorkoutEntry{
+		ExerciseName: "Bench Press",
+		Sets: []WorkoutSet{
+			{SetType: SetTypeWarmup, Reps: IntPointer(1), Weight: FloatPointer(120)},
+			{Reps: IntPointer(12), Weight: FloatPointer(60)},
+			{Reps: IntPointer(10), Weight: FloatPointer(70)},
+			{Reps: IntPointer(8), Weight: FloatPointer(80)},
+			{Reps: IntPointer(6), Weight: FloatPointer(80)},
+		},
+	}
+
+	byType := map[string][]PersonalRecord{}
+	for _, candidate := range recordCandidates(entry) {
+		byType[candidate.RecordType] = append(byType[candidate.RecordType], candidate)
+	}
+
+	require.Len(t, byType[RecordMaxWeight], 1)
+	assert.Equal(t, 80.0, byType[RecordMaxWeight][0].Value)
+	assert.Equal(t, 8, *byType[RecordMaxWeight][0].Reps)
+
+	require.Len(t, byType[RecordMaxE1RM], 1)
+	assert.Equal(t, 101.33, byType[RecordMaxE1RM][0].Value)
+
+	require.Len(t, byType[RecordMaxReps], 3)
+	assert.Equal(t, 8.0, byType[RecordMaxReps][2].Value)
+
+	assert.Empty(t, byType[RecordMaxDuration])
+}
+
+func TestPersonalRecordDetection(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	workoutStore := NewPostgresWorkoutStore(db)
+	recordStore := NewPostgresRecordStore(db)
+	user := createTestUser(t, db, "recordbreaker")
+
+	first, err := workoutStore.CreateWorkout(ctx, &Workout{
+		UserID:          user.ID,
+		Title:           "week 1",
+		DurationMinutes: 30,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "Bench Press", Sets: ExpandSets(3, IntPointer(5), nil, FloatPointer(80)), OrderIndex: 1},
+		},
+	})
+	require.NoError(t, err)
+	assert.Len(t, first.NewRecords, 3) // weight, e1rm and reps at 80
+
+	second, err := workoutStore.CreateWorkout(ctx, &Workout{
+		UserID:          user.ID,
+		Title:           "week 2",
+		DurationMinutes: 30,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "bench", Sets: ExpandSets(3, IntPointer(4), nil, FloatPointer(80)), OrderIndex: 1},
+		},
+	})
+	require.NoError(t, err)
+	assert.Empty(t, second.NewRecords)
+
+	second.Entries[0].Sets = ExpandSets(3, IntPointer(6), nil, FloatPointer(85))
+	require.NoError(t, workoutStore.UpdateWorkout(ctx, second, user.ID))
+	assert.Len(t, second.NewRecords, 3)
+
+	current, err := recordStore.GetCurrentRecords(ctx, user.ID)
+	require.NoError(t, err)
+	for _, record := range current {
+		if record.RecordType == RecordMaxWeight {
+			assert.Equal(t, 85.0, record.Value)
+		}
+	}
+
+	history, err := recordStore.GetRecordHistory(ctx, user.ID, int64(*first.Entries[0].ExerciseID))
+	require.NoError(t, err)
+	assert.Len(t, history, 6)
+}
+
+func TestRecordsFollowEditedHistory(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	workoutStore := NewPostgresWorkoutStore(db)
+	recordStore := NewPostgresRecordStore(db)
+	user := createTestUser(t, db, "revisionist")
+
+	logBench := func(title string, weight float64) *Workout {
+		workout, err := workoutStore.CreateWorkout(ctx, &Workout{
+			UserID:          user.ID,
+			Title:           title,
+			DurationMinutes: 30,
+			Entries: []WorkoutEntry{
+				{ExerciseName: "Bench Press", Sets: ExpandSets(1, IntPointer(5), nil, FloatPointer(weight)), OrderIndex: 1},
+			},
+		})
+		require.NoError(t, err)
+		return workout
+	}
+	maxWeight := func() *PersonalRecord {
+		current, err := recordStore.GetCurrentRecords(ctx, user.ID)
+		require.NoError(t, err)
+		for _, record := range current {
+			if record.RecordType == RecordMaxWeight {
+				return record
+			}
+		}
+		return nil
+	}
+
+	first := logBench("week 1", 100)
+	second := logBench("week 2", 90)
+	third := logBench("week 3", 85)
+	assert.Equal(t, 100.0, maxWeight().Value)
+
+	// with the old 100kg gone, the later 90kg is the record it always should have been
+	first.Entries[0].Sets = ExpandSets(1, IntPointer(5), nil, FloatPointer(80))
+	require.NoError(t, workoutStore.UpdateWorkout(ctx, first, user.ID))
+	assert.Len(t, first.NewRecords, 3)
+
+	record := maxWeight()
+	require.NotNil(t, record)
+	assert.Equal(t, 90.0, record.Value)
+	assert.Equal(t, second.ID, record.WorkoutID)
+
+	require.NoError(t, workoutStore.DeleteWorkout(ctx, int64(second.ID), user.ID))
+	record = maxWeight()
+	require.NotNil(t, record)
+	assert.Equal(t, 85.0, record.Value)
+	assert.Equal(t, third.ID, record.WorkoutID)
+
+	history, err := recordStore.GetRecordHistory(ctx, user.ID, int64(*first.Entries[0].ExerciseID))
+	require.NoError(t, err)
+	for _, record := range history {
+		assert.NotEqual(t, second.ID, record.WorkoutID)
+	}
+}
@@ -1,117 +1,204 @@
 package store
 
 import (
+	"context"
 	"database/sql"
-	"errors"
+	"time"
+
+	"github.com/nickemma/internal/validator"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type Workout struct {
 	ID              int            `json:"id"`
+	UserID          int            `json:"user_id"`
 	Title           string         `json:"title"`
 	Description     string         `json:"description"`
 	DurationMinutes int            `json:"duration_minutes"`
 	CaloriesBurned  int            `json:"calories_burned"`
+	CreatedAt       time.Time      `json:"created_at"`
 	Entries         []WorkoutEntry `json:"entries"`
+
+	// NewRecords the personal records set by the last create or update
+	NewRecords []PersonalRecord `json:"-"`
 }
 
+// WorkoutEntry one exercise of a workout. Reps, DurationSeconds and Weight
+// are the summary of the top set, the details live in Sets
 type WorkoutEntry struct {
-	ID              int      `json:"id"`
-	ExerciseName    string   `json:"exercise_name"`
-	Sets            int      `json:"sets"`
-	Reps            *int     `json:"reps"`
-	DurationSeconds *int     `json:"duration_seconds"`
-	Weight          *float64 `json:"weight"`
-	Notes           string   `json:"notes"`
-	OrderIndex      int      `json:"order_index"`
+	ID              int          `json:"id"`
+	ExerciseID      *int         `json:"exercise_id"`
+	ExerciseName    string       `json:"exercise_name"`
+	Sets            []WorkoutSet `json:"sets"`
+	Reps            *int         `json:"reps"`
+	DurationSeconds *int         `json:"duration_seconds"`
+	Weight          *float64     `json:"weight"`
+	Notes           string       `json:"notes"`
+	OrderIndex      int          `json:"order_index"`
+
+	setCount int // the aggregate sets column
+}
+
+// ValidateWorkout the owner is never part of the request, so it isn't checked
+func ValidateWorkout(v *validator.Validator, workout *Workout) {
+	v.Check(workout.Title != "", "title", "must be provided")
+	v.Check(len(workout.Title) <= 50, "title", "must not be more than 50 bytes long")
+	v.Check(workout.DurationMinutes > 0, "duration_minutes", "must be greater than zero")
+	v.Check(workout.DurationMinutes <= 24*60, "duration_minutes", "must not be more than a day")
+	v.Check(workout.CaloriesBurned >= 0, "calories_burned", "must not be negative")
+	ValidateEntries(v, workout.Entries)
 }
 
 type PostgresWorkoutStore struct {
 	db *sql.DB
+	queryTimeout
 }
 
 func NewPostgresWorkoutStore(db *sql.DB) *PostgresWorkoutStore {
-	return &PostgresWorkoutStore{db: db}
+	return &PostgresWorkoutStore{db: db, queryTimeout: queryTimeout{timeout: DefaultQueryTimeout}}
 }
 
+// ErrWorkoutForbidden is returned when a workout exists but belongs to another user
+var ErrWorkoutForbidden = newStoreError(ErrForbidden, "workout does not belong to user")
+
+// WorkoutStore every read/write is scoped to the owning user, so a handler
+// can't load or modify someone else's workout by id alone. reads are also
+// open to the coaches of the owner, writes never are
 type WorkoutStore interface {
-	CreateWorkout(*Workout) (*Workout, error)
-	GetWorkoutByID(id int64) (*Workout, error)
-	GetWorkoutByID1(id int64) (*Workout, error)
-	UpdateWorkout(*Workout) error
-	DeleteWorkout(id int64) error
+	CreateWorkout(ctx context.Context, workout *Workout) (*Workout, error)
+	GetWorkoutByID(ctx context.Context, id int64, userID int) (*Workout, error)
+	GetWorkoutByID1(ctx context.Context, id int64, userID int) (*Workout, error)
+	GetAnyWorkoutByID(ctx context.Context, id int64) (*Workout, error)
+	UpdateWorkout(ctx context.Context, workout *Workout, userID int) error
+	DeleteWorkout(ctx context.Context, id int64, userID int) error
+	ListWorkouts(ctx context.Context, filter WorkoutFilter) ([]*Workout, string, error)
+	AddEntryComment(ctx context.Context, workoutID int64, comment *EntryComment, userID int) error
+	ListWorkoutComments(ctx context.Context, workoutID int64, userID int) ([]*EntryComment, error)
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// checkWorkoutOwner returns ErrNotFound when the workout does not exist
+// and ErrWorkoutForbidden when it is owned by someone else
+func checkWorkoutOwner(ctx context.Context, q queryRower, id int64, userID int) error {
+	var ownerID int
+	err := q.QueryRowContext(ctx, `SELECT user_id FROM workouts WHERE id = $1`, id).Scan(&ownerID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	if ownerID != userID {
+		return ErrWorkoutForbidden
+	}
+
+	return nil
+}
+
+// checkWorkoutReader like checkWorkoutOwner, but the coaches of the owner
+// may read the workout too
+func checkWorkoutReader(ctx context.Context, q queryRower, id int64, userID int) error {
+	var readable bool
+	query := `SELECT ` + workoutReadableBy("w.user_id", "$2") + ` FROM workouts w WHERE w.id = $1`
+	err := q.QueryRowContext(ctx, query, id, userID).Scan(&readable)
+	if err != nil {
+		return translateError(err)
+	}
+
+	if !readable {
+		return ErrWorkoutForbidden
+	}
+
+	return nil
 }
 
 // CreateWorkout Creating a workout transaction
-func (pg *PostgresWorkoutStore) CreateWorkout(workout *Workout) (*Workout, error) {
+func (pg *PostgresWorkoutStore) CreateWorkout(ctx context.Context, workout *Workout) (*Workout, error) {
+	ctx, span := startSpan(ctx, "WorkoutStore.CreateWorkout")
+	defer span.End()
+	span.SetAttributes(attribute.Int("workout.entries", len(workout.Entries)))
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
 	// Beginning the sql transaction and commiting it to the database
-	tx, err := pg.db.Begin()
+	tx, err := pg.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 	// rolling back our transaction in case of failed transactions or error
 	defer tx.Rollback()
 
 	// inserting the data into our database
 	query := `
-INSERT INTO workouts (title, description, duration_minutes, calories_burned) 
-VALUES ($1, $2, $3, $4) 
-RETURNING id
+INSERT INTO workouts (user_id, title, description, duration_minutes, calories_burned) 
+VALUES ($1, $2, $3, $4, $5) 
+RETURNING id, created_at
 `
-	err = tx.QueryRow(query, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned).Scan(&workout.ID)
+	err = tx.QueryRowContext(ctx, query, workout.UserID, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned).Scan(&workout.ID, &workout.CreatedAt)
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	for i := range workout.Entries {
-		entry := &workout.Entries[i]
-		query := `
-INSERT INTO workout_entries (workout_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index) 
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
-RETURNING id
-    `
-		err = tx.QueryRow(query, workout.ID, entry.ExerciseName, entry.Sets, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex).Scan(&entry.ID)
+		err = insertWorkoutEntry(ctx, tx, workout.ID, workout.UserID, &workout.Entries[i])
 		if err != nil {
-			return nil, err
+			return nil, translateError(err)
 		}
 	}
 
+	workout.NewRecords, err = detectPersonalRecords(ctx, tx, workout)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
 	// commiting the transaction
 	err = tx.Commit()
 
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	return workout, nil
 }
 
 // GetWorkoutById getting the workout by id
-func (pg *PostgresWorkoutStore) GetWorkoutByID(id int64) (*Workout, error) {
+func (pg *PostgresWorkoutStore) GetWorkoutByID(ctx context.Context, id int64, userID int) (*Workout, error) {
+	ctx, span := startSpan(ctx, "WorkoutStore.GetWorkoutByID")
+	defer span.End()
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	err := checkWorkoutReader(ctx, pg.db, id, userID)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
 	workout := &Workout{}
 	query := `
-	SELECT id, title, description, duration_minutes, calories_burned
+	SELECT id, user_id, title, description, duration_minutes, calories_burned, created_at
     FROM workouts
     WHERE id = $1;
 `
-	err := pg.db.QueryRow(query, id).Scan(&workout.ID, &workout.Title, &workout.Description, &workout.DurationMinutes, &workout.CaloriesBurned)
-	if errors.Is(err, sql.ErrNoRows) {
-		return nil, nil
-	}
-
+	err = pg.db.QueryRowContext(ctx, query, id).Scan(&workout.ID, &workout.UserID, &workout.Title, &workout.Description, &workout.DurationMinutes, &workout.CaloriesBurned, &workout.CreatedAt)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	entryQuery := `
-   SELECT id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index
+   SELECT id, exercise_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index
 FROM workout_entries
 WHERE workout_id = $1
 ORDER BY order_index
 `
-	rows, err := pg.db.Query(entryQuery, id)
+	rows, err := pg.db.QueryContext(ctx, entryQuery, id)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
 	defer rows.Close()
@@ -120,8 +207,9 @@ ORDER BY order_index
 		var entry WorkoutEntry
 		err = rows.Scan(
 			&entry.ID,
+			&entry.ExerciseID,
 			&entry.ExerciseName,
-			&entry.Sets,
+			&entry.setCount,
 			&entry.Reps,
 			&entry.DurationSeconds,
 			&entry.Weight,
@@ -129,26 +217,60 @@ ORDER BY order_index
 			&entry.OrderIndex,
 		)
 		if err != nil {
-			return nil, err
+			return nil, translateError(err)
 		}
 		workout.Entries = append(workout.Entries, entry)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, translateError(err)
+	}
+
+	err = attachSets(ctx, pg.db, workoutSetsTable, workoutEntryPointers(workout))
+	if err != nil {
+		return nil, translateError(err)
+	}
 	return workout, nil
 }
 
 // GetWorkoutByID1 getting the workout by id another method
-func (pg *PostgresWorkoutStore) GetWorkoutByID1(id int64) (*Workout, error) {
+func (pg *PostgresWorkoutStore) GetWorkoutByID1(ctx context.Context, id int64, userID int) (*Workout, error) {
+	ctx, span := startSpan(ctx, "WorkoutStore.GetWorkoutByID1")
+	defer span.End()
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	err := checkWorkoutReader(ctx, pg.db, id, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	return pg.getWorkout(ctx, id)
+}
+
+// GetAnyWorkoutByID the workout whoever owns it, for admins. callers check
+// the permission
+func (pg *PostgresWorkoutStore) GetAnyWorkoutByID(ctx context.Context, id int64) (*Workout, error) {
+	ctx, span := startSpan(ctx, "WorkoutStore.GetAnyWorkoutByID")
+	defer span.End()
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	return pg.getWorkout(ctx, id)
+}
+
+// getWorkout the workout with its entries and sets, ErrNotFound when it doesn't exist
+func (pg *PostgresWorkoutStore) getWorkout(ctx context.Context, id int64) (*Workout, error) {
 	query := `
         SELECT 
-            w.id, w.title, w.description, w.duration_minutes, w.calories_burned,
-            e.id, e.exercise_name, e.sets, e.reps, e.duration_seconds, e.weight, e.notes, e.order_index
+            w.id, w.user_id, w.title, w.description, w.duration_minutes, w.calories_burned, w.created_at,
+            e.id, e.exercise_id, e.exercise_name, e.sets, e.reps, e.duration_seconds, e.weight, e.notes, e.order_index
         FROM workouts w
         LEFT JOIN workout_entries e ON w.id = e.workout_id
         WHERE w.id = $1
         ORDER BY e.order_index
     `
 
-	rows, err := pg.db.Query(query, id)
+	rows, err := pg.db.QueryContext(ctx, query, id)
 	if err != nil {
 		return nil, err
 	}
@@ -160,9 +282,9 @@ func (pg *PostgresWorkoutStore) GetWorkoutByID1(id int64) (*Workout, error) {
 	for rows.Next() {
 		var entry WorkoutEntry
 		err := rows.Scan(
-			&workout.ID, &workout.Title, &workout.Description,
-			&workout.DurationMinutes, &workout.CaloriesBurned,
-			&entry.ID, &entry.ExerciseName, &entry.Sets, &entry.Reps,
+			&workout.ID, &workout.UserID, &workout.Title, &workout.Description,
+			&workout.DurationMinutes, &workout.CaloriesBurned, &workout.CreatedAt,
+			&entry.ID, &entry.ExerciseID, &entry.ExerciseName, &entry.setCount, &entry.Reps,
 			&entry.DurationSeconds, &entry.Weight, &entry.Notes, &entry.OrderIndex,
 		)
 		if err != nil {
@@ -177,7 +299,7 @@ func (pg *PostgresWorkoutStore) GetWorkoutByID1(id int64) (*Workout, error) {
 	}
 
 	if workout.ID == 0 { // No workout found
-		return nil, nil
+		return nil, ErrNotFound
 	}
 
 	// Handle case where no entries exist
@@ -185,83 +307,120 @@ func (pg *PostgresWorkoutStore) GetWorkoutByID1(id int64) (*Workout, error) {
 		workout.Entries = []WorkoutEntry{}
 	}
 
+	err = attachSets(ctx, pg.db, workoutSetsTable, workoutEntryPointers(&workout))
+	if err != nil {
+		return nil, err
+	}
+
 	return &workout, nil
 }
 
 // UpdateWorkout Update a workout
-func (pg *PostgresWorkoutStore) UpdateWorkout(workout *Workout) error {
-	tx, err := pg.db.Begin()
+func (pg *PostgresWorkoutStore) UpdateWorkout(ctx context.Context, workout *Workout, userID int) error {
+	ctx, span := startSpan(ctx, "WorkoutStore.UpdateWorkout")
+	defer span.End()
+	span.SetAttributes(attribute.Int("workout.entries", len(workout.Entries)))
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := pg.db.BeginTx(ctx, nil)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
 	defer tx.Rollback()
 
+	err = checkWorkoutOwner(ctx, tx, int64(workout.ID), userID)
+	if err != nil {
+		return translateError(err)
+	}
+	workout.UserID = userID
+
+	// the exercises the workout held records on before the edit
+	recordKeys, err := workoutRecordKeys(ctx, tx, workout.ID)
+	if err != nil {
+		return translateError(err)
+	}
+
 	query := `
 UPDATE workouts 
-SET title = $1, description = $2, duration_minutes = $3, calories_burned = $4
-WHERE id = $5
+SET title = $1, description = $2, duration_minutes = $3, calories_burned = $4, updated_at = CURRENT_TIMESTAMP
+WHERE id = $5 AND user_id = $6
+RETURNING created_at
 `
 
-	result, err := tx.Exec(query, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned, workout.ID)
+	err = tx.QueryRowContext(ctx, query, workout.Title, workout.Description, workout.DurationMinutes, workout.CaloriesBurned, workout.ID, userID).Scan(&workout.CreatedAt)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
-	rowsAffected, err := result.RowsAffected()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM workout_entries WHERE workout_id = $1`, workout.ID)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	for i := range workout.Entries {
+		err = insertWorkoutEntry(ctx, tx, workout.ID, userID, &workout.Entries[i])
+		if err != nil {
+			return translateError(err)
+		}
+		recordKeys = append(recordKeys, recordKey(&workout.Entries[i]))
 	}
 
-	_, err = tx.Exec(`DELETE FROM workout_entries WHERE workout_id = $1`, workout.ID)
+	// the records of this workout and of every later one on the same
+	// exercises are recomputed from the new entries
+	records, err := recomputeRecords(ctx, tx, userID, workout.CreatedAt, recordKeys)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
-
-	for _, entry := range workout.Entries {
-		query := `
-INSERT INTO workout_entries (workout_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index) 
-VALUES ($1, $2, $3, $4, $5, $6, $7, $8) 
-`
-		_, err := tx.Exec(
-			query,
-			workout.ID,
-			entry.ExerciseName,
-			entry.Sets,
-			entry.Reps,
-			entry.DurationSeconds,
-			entry.Weight,
-			entry.Notes,
-			entry.OrderIndex,
-		)
-		if err != nil {
-			return err
-		}
+	workout.NewRecords = records[workout.ID]
+	if workout.NewRecords == nil {
+		workout.NewRecords = []PersonalRecord{}
 	}
-	return tx.Commit()
+
+	return translateError(tx.Commit())
 }
 
 // DeleteWorkout deletes a workout
-func (pg *PostgresWorkoutStore) DeleteWorkout(id int64) error {
+func (pg *PostgresWorkoutStore) DeleteWorkout(ctx context.Context, id int64, userID int) error {
+	ctx, span := startSpan(ctx, "WorkoutStore.DeleteWorkout")
+	defer span.End()
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	err = checkWorkoutOwner(ctx, tx, id, userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	// the records go with the workout, later workouts on the same exercises
+	// may hold records again
+	recordKeys, err := workoutRecordKeys(ctx, tx, int(id))
+	if err != nil {
+		return translateError(err)
+	}
+
 	query := `
 DELETE FROM workouts
-WHERE id = $1
+WHERE id = $1 AND user_id = $2
+RETURNING created_at
 `
-	res, err := pg.db.Exec(query, id)
-	if err != nil {
-		return err
-	}
-	rowsAffected, err := res.RowsAffected()
+	var createdAt time.Time
+	err = tx.QueryRowContext(ctx, query, id, userID).Scan(&createdAt)
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
-	if rowsAffected == 0 {
-		return sql.ErrNoRows
+	_, err = recomputeRecords(ctx, tx, userID, createdAt, recordKeys)
+	if err != nil {
+		return translateError(err)
 	}
 
-	return nil
+	return translateError(tx.Commit())
 }
@@ -0,0 +1,367 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/nickemma/internal/validator"
+)
+
+// WorkoutTemplate a saved workout structure with the planned entries,
+// used to start new workouts without re-posting the whole thing
+type WorkoutTemplate struct {
+	ID              int            `json:"id"`
+	UserID          int            `json:"user_id"`
+	Title           string         `json:"title"`
+	Description     string         `json:"description"`
+	DurationMinutes int            `json:"duration_minutes"`
+	CreatedAt       time.Time      `json:"created_at"`
+	Entries         []WorkoutEntry `json:"entries"`
+}
+
+func ValidateTemplate(v *validator.Validator, template *WorkoutTemplate) {
+	v.Check(template.Title != "", "title", "must be provided")
+	v.Check(len(template.Title) <= 50, "title", "must not be more than 50 bytes long")
+	v.Check(template.DurationMinutes > 0, "duration_minutes", "must be greater than zero")
+	v.Check(template.DurationMinutes <= 24*60, "duration_minutes", "must not be more than a day")
+	ValidateEntries(v, template.Entries)
+}
+
+// ErrTemplateForbidden is returned when a template exists but belongs to another user
+var ErrTemplateForbidden = newStoreError(ErrForbidden, "template does not belong to user")
+
+type PostgresTemplateStore struct {
+	db *sql.DB
+	queryTimeout
+}
+
+func NewPostgresTemplateStore(db *sql.DB) *PostgresTemplateStore {
+	return &PostgresTemplateStore{db: db, queryTimeout: queryTimeout{timeout: DefaultQueryTimeout}}
+}
+
+type TemplateStore interface {
+	CreateTemplate(ctx context.Context, template *WorkoutTemplate) (*WorkoutTemplate, error)
+	GetTemplateByID(ctx context.Context, id int64, userID int) (*WorkoutTemplate, error)
+	ListTemplates(ctx context.Context, userID int) ([]*WorkoutTemplate, error)
+	UpdateTemplate(ctx context.Context, template *WorkoutTemplate, userID int) error
+	DeleteTemplate(ctx context.Context, id int64, userID int) error
+}
+
+func checkTemplateOwner(ctx context.Context, q queryRower, id int64, userID int) error {
+	var ownerID int
+	err := q.QueryRowContext(ctx, `SELECT user_id FROM workout_templates WHERE id = $1`, id).Scan(&ownerID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	if ownerID != userID {
+		return ErrTemplateForbidden
+	}
+
+	return nil
+}
+
+func insertTemplateEntries(ctx context.Context, tx *sql.Tx, templateID, userID int, entries []WorkoutEntry) error {
+	query := `
+INSERT INTO template_entries (template_id, exercise_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING id
+`
+	for i := range entries {
+		entry := &entries[i]
+		entry.summarizeSets()
+		err := resolveExercise(ctx, tx, userID, entry)
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, query, templateID, entry.ExerciseID, entry.ExerciseName, entry.setCount, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex).Scan(&entry.ID)
+		if err != nil {
+			return err
+		}
+
+		err = insertSets(ctx, tx, templateSetsTable, entry)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateTemplate saves the template and its planned entries in one transaction
+func (pg *PostgresTemplateStore) CreateTemplate(ctx context.Context, template *WorkoutTemplate) (*WorkoutTemplate, error) {
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, translateError(err)
+	}
+	defer tx.Rollback()
+
+	query := `
+INSERT INTO workout_templates (user_id, title, description, duration_minutes)
+VALUES ($1, $2, $3, $4)
+RETURNING id, created_at
+`
+	err = tx.QueryRowContext(ctx, query, template.UserID, template.Title, template.Description, template.DurationMinutes).Scan(&template.ID, &template.CreatedAt)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	err = insertTemplateEntries(ctx, tx, template.ID, template.UserID, template.Entries)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return template, nil
+}
+
+// GetTemplateByID returns ErrNotFound when the template does not exist
+func (pg *PostgresTemplateStore) GetTemplateByID(ctx context.Context, id int64, userID int) (*WorkoutTemplate, error) {
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	template := &WorkoutTemplate{}
+	query := `
+SELECT id, user_id, title, COALESCE(description, ''), duration_minutes, created_at
+FROM workout_templates
+WHERE id = $1
+`
+	err := pg.db.QueryRowContext(ctx, query, id).Scan(&template.ID, &template.UserID, &template.Title, &template.Description, &template.DurationMinutes, &template.CreatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if template.UserID != userID {
+		return nil, ErrTemplateForbidden
+	}
+
+	template.Entries, err = pg.getTemplateEntries(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return template, nil
+}
+
+func (pg *PostgresTemplateStore) getTemplateEntries(ctx context.Context, templateID int64) ([]WorkoutEntry, error) {
+	query := `
+SELECT id, exercise_id, exercise_name, sets, reps, duration_seconds, weight, COALESCE(notes, ''), order_index
+FROM template_entries
+WHERE template_id = $1
+ORDER BY order_index
+`
+	rows, err := pg.db.QueryContext(ctx, query, templateID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []WorkoutEntry{}
+	for rows.Next() {
+		var entry WorkoutEntry
+		err = rows.Scan(
+			&entry.ID,
+			&entry.ExerciseID,
+			&entry.ExerciseName,
+			&entry.setCount,
+			&entry.Reps,
+			&entry.DurationSeconds,
+			&entry.Weight,
+			&entry.Notes,
+			&entry.OrderIndex,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pointers := make([]*WorkoutEntry, len(entries))
+	for i := range entries {
+		pointers[i] = &entries[i]
+	}
+	err = attachSets(ctx, pg.db, templateSetsTable, pointers)
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// ListTemplates returns every template of the user, entries included
+func (pg *PostgresTemplateStore) ListTemplates(ctx context.Context, userID int) ([]*WorkoutTemplate, error) {
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+SELECT id, user_id, title, COALESCE(description, ''), duration_minutes, created_at
+FROM workout_templates
+WHERE user_id = $1
+ORDER BY title, id
+`
+	rows, err := pg.db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []*WorkoutTemplate{}
+	for rows.Next() {
+		template := &WorkoutTemplate{}
+		err = rows.Scan(&template.ID, &template.UserID, &template.Title, &template.Description, &template.DurationMinutes, &template.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, template)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = pg.loadTemplateEntries(ctx, templates)
+	if err != nil {
+		return nil, err
+	}
+
+	return templates, nil
+}
+
+// loadTemplateEntries fills in the entries for a list of templates in a single query
+func (pg *PostgresTemplateStore) loadTemplateEntries(ctx context.Context, templates []*WorkoutTemplate) error {
+	if len(templates) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(templates))
+	byID := make(map[int]*WorkoutTemplate, len(templates))
+	for i, template := range templates {
+		ids[i] = int64(template.ID)
+		byID[template.ID] = template
+		template.Entries = []WorkoutEntry{}
+	}
+
+	query := `
+SELECT template_id, id, exercise_id, exercise_name, sets, reps, duration_seconds, weight, COALESCE(notes, ''), order_index
+FROM template_entries
+WHERE template_id = ANY($1)
+ORDER BY template_id, order_index
+`
+	rows, err := pg.db.QueryContext(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var templateID int
+		var entry WorkoutEntry
+		err = rows.Scan(
+			&templateID,
+			&entry.ID,
+			&entry.ExerciseID,
+			&entry.ExerciseName,
+			&entry.setCount,
+			&entry.Reps,
+			&entry.DurationSeconds,
+			&entry.Weight,
+			&entry.Notes,
+			&entry.OrderIndex,
+		)
+		if err != nil {
+			return err
+		}
+		template := byID[templateID]
+		template.Entries = append(template.Entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	var pointers []*WorkoutEntry
+	for _, template := range templates {
+		for i := range template.Entries {
+			pointers = append(pointers, &template.Entries[i])
+		}
+	}
+	return attachSets(ctx, pg.db, templateSetsTable, pointers)
+}
+
+// UpdateTemplate replaces the template fields and all of its entries
+func (pg *PostgresTemplateStore) UpdateTemplate(ctx context.Context, template *WorkoutTemplate, userID int) error {
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := pg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	err = checkTemplateOwner(ctx, tx, int64(template.ID), userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	query := `
+UPDATE workout_templates
+SET title = $1, description = $2, duration_minutes = $3, updated_at = CURRENT_TIMESTAMP
+WHERE id = $4 AND user_id = $5
+`
+	_, err = tx.ExecContext(ctx, query, template.Title, template.Description, template.DurationMinutes, template.ID, userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM template_entries WHERE template_id = $1`, template.ID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	err = insertTemplateEntries(ctx, tx, template.ID, userID, template.Entries)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return translateError(tx.Commit())
+}
+
+// DeleteTemplate returns ErrNotFound when the template does not exist
+func (pg *PostgresTemplateStore) DeleteTemplate(ctx context.Context, id int64, userID int) error {
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	err := checkTemplateOwner(ctx, pg.db, id, userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	res, err := pg.db.ExecContext(ctx, `DELETE FROM workout_templates WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return translateError(err)
+	}
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return translateError(err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
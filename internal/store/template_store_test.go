@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTemplateCRUD(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresTemplateStore(db)
+	owner := createTestUser(t, db, "coachless")
+	other := createTestUser(t, db, "nosy")
+
+	template, err := store.CreateTemplate(ctx, &WorkoutTemplate{
+		UserID:          owner.ID,
+		Title:           "push day",
+		DurationMinutes: 60,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "Bench press", Sets: ExpandSets(3, IntPointer(10), nil, FloatPointer(60)), OrderIndex: 1},
+			{ExerciseName: "Plank", Sets: ExpandSets(3, nil, IntPointer(60), nil), OrderIndex: 2},
+		},
+	})
+	require.NoError(t, err)
+
+	retrieved, err := store.GetTemplateByID(ctx, int64(template.ID), owner.ID)
+	require.NoError(t, err)
+	require.Len(t, retrieved.Entries, 2)
+	assert.Equal(t, "Bench press", retrieved.Entries[0].ExerciseName)
+
+	_, err = store.GetTemplateByID(ctx, int64(template.ID), other.ID)
+	assert.ErrorIs(t, err, ErrTemplateForbidden)
+
+	retrieved.Entries = retrieved.Entries[:1]
+	require.NoError(t, store.UpdateTemplate(ctx, retrieved, owner.ID))
+
+	templates, err := store.ListTemplates(ctx, owner.ID)
+	require.NoError(t, err)
+	require.Len(t, templates, 1)
+	assert.Len(t, templates[0].Entries, 1)
+
+	assert.ErrorIs(t, store.DeleteTemplate(ctx, int64(template.ID), other.ID), ErrTemplateForbidden)
+	require.NoError(t, store.DeleteTemplate(ctx, int64(template.ID), owner.ID))
+}
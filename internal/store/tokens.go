@@ -1,7 +1,10 @@
 package store
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"errors"
 	"time"
 
 	"github.com/nickemma/internal/tokens"
@@ -9,46 +12,350 @@ import (
 
 type PostgresTokenStore struct {
 	db *sql.DB
+	queryTimeout
+
+	accessTTL  time.Duration
+	refreshTTL time.Duration
 }
 
 func NewPostgresTokenStore(db *sql.DB) *PostgresTokenStore {
 	return &PostgresTokenStore{
-		db: db,
+		db:           db,
+		queryTimeout: queryTimeout{timeout: DefaultQueryTimeout},
+		accessTTL:    tokens.AccessTokenTTL,
+		refreshTTL:   tokens.RefreshTokenTTL,
 	}
 }
 
+// SetTokenTTLs the lifetimes of the token pairs handed out on login and refresh
+func (t *PostgresTokenStore) SetTokenTTLs(access, refresh time.Duration) {
+	t.accessTTL = access
+	t.refreshTTL = refresh
+}
+
+// Session an active authentication token as shown to its owner, the token
+// itself is never listed
+type Session struct {
+	ID         int        `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	Expiry     time.Time  `json:"expiry"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	UserAgent  string     `json:"user_agent"`
+	Current    bool       `json:"current"`
+}
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+	ErrRefreshTokenReused  = errors.New("refresh token reuse detected")
+)
+
+// sessionScopes the tokens that log a user in, or are about to
+var sessionScopes = []string{tokens.ScopeAuth, tokens.ScopeRefresh, tokens.ScopeTwoFactorPending}
+
 type TokenStore interface {
-	Insert(token *tokens.Token) error
-	CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error)
-	DeleteAllTokensForUser(userID int, scope string) error
+	Insert(ctx context.Context, token *tokens.Token) error
+	CreateNewToken(ctx context.Context, userID int, ttl time.Duration, scope string) (*tokens.Token, error)
+	DeleteAllTokensForUser(ctx context.Context, userID int, scope string) error
+	DeleteToken(ctx context.Context, scope, tokenPlainText string) error
+	DeleteOtherSessions(ctx context.Context, userID int, currentTokenPlainText string) error
+	DeleteSessions(ctx context.Context, userID int, audit *AuditEntry) error
+	ListSessions(ctx context.Context, userID int, currentTokenPlainText string) ([]*Session, error)
+	CreateTokenPair(ctx context.Context, userID int, userAgent string) (*tokens.Token, *tokens.Token, error)
+	RotateRefreshToken(ctx context.Context, refreshPlainText, userAgent string) (*tokens.Token, *tokens.Token, error)
 }
 
-func (t *PostgresTokenStore) CreateNewToken(userID int, ttl time.Duration, scope string) (*tokens.Token, error) {
+func (t *PostgresTokenStore) CreateNewToken(ctx context.Context, userID int, ttl time.Duration, scope string) (*tokens.Token, error) {
+	ctx, span := startSpan(ctx, "TokenStore.CreateNewToken")
+	defer span.End()
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
 	token, err := tokens.GenerateToken(userID, ttl, scope)
 	if err != nil {
-		return nil, err
+		return nil, translateError(err)
 	}
 
-	err = t.Insert(token)
+	err = t.Insert(ctx, token)
 	return token, err
 }
 
-func (t *PostgresTokenStore) Insert(token *tokens.Token) error {
+func (t *PostgresTokenStore) Insert(ctx context.Context, token *tokens.Token) error {
+	ctx, span := startSpan(ctx, "TokenStore.Insert")
+	defer span.End()
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
 	query := `
-  INSERT INTO tokens (hash, user_id, expiry, scope)
-  VALUES ($1, $2, $3, $4)
+  INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, family_id)
+  VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))
   `
 
-	_, err := t.db.Exec(query, token.Hash, token.UserID, token.Expiry, token.Scope)
-	return err
+	_, err := t.db.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.Family)
+	return translateError(err)
+}
+
+// insertTokenPair a short-lived access token and the refresh token that can
+// replace it, both in the given family
+func (t *PostgresTokenStore) insertTokenPair(ctx context.Context, tx *sql.Tx, userID int, userAgent, family string) (*tokens.Token, *tokens.Token, error) {
+	query := `
+  INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, family_id)
+  VALUES ($1, $2, $3, $4, $5, $6)
+  `
+
+	access, err := tokens.GenerateToken(userID, t.accessTTL, tokens.ScopeAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+	refresh, err := tokens.GenerateToken(userID, t.refreshTTL, tokens.ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, token := range []*tokens.Token{access, refresh} {
+		token.UserAgent = userAgent
+		token.Family = family
+		_, err = tx.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.Family)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, nil
+}
+
+// CreateTokenPair starts a new token family for a fresh login
+func (t *PostgresTokenStore) CreateTokenPair(ctx context.Context, userID int, userAgent string) (*tokens.Token, *tokens.Token, error) {
+	ctx, span := startSpan(ctx, "TokenStore.CreateTokenPair")
+	defer span.End()
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	family, err := tokens.NewFamily()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	access, refresh, err := t.insertTokenPair(ctx, tx, userID, userAgent, family)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// RotateRefreshToken swaps a refresh token for a new access and refresh
+// token. rotated refresh tokens are kept until they expire, presenting one
+// again means it leaked, so the whole family is revoked
+func (t *PostgresTokenStore) RotateRefreshToken(ctx context.Context, refreshPlainText, userAgent string) (*tokens.Token, *tokens.Token, error) {
+	ctx, span := startSpan(ctx, "TokenStore.RotateRefreshToken")
+	defer span.End()
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+  SELECT user_id, family_id, rotated_at
+  FROM tokens
+  WHERE hash = $1 AND scope = $2 AND expiry > $3
+  FOR UPDATE
+  `
+
+	var userID int
+	var family sql.NullString
+	var rotatedAt *time.Time
+	err = tx.QueryRowContext(ctx, query, tokens.Hash(refreshPlainText), tokens.ScopeRefresh, time.Now()).Scan(&userID, &family, &rotatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrInvalidRefreshToken
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if rotatedAt != nil {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family_id = $1`, family.String)
+		if err != nil {
+			return nil, nil, err
+		}
+		err = tx.Commit()
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, nil, ErrRefreshTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET rotated_at = NOW() WHERE hash = $1`, tokens.Hash(refreshPlainText))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the family keeps a single live access token
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family_id = $1 AND scope = $2`, family.String, tokens.ScopeAuth)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := t.insertTokenPair(ctx, tx, userID, userAgent, family.String)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
 }
 
-func (t *PostgresTokenStore) DeleteAllTokensForUser(userID int, scope string) error {
+func (t *PostgresTokenStore) DeleteAllTokensForUser(ctx context.Context, userID int, scope string) error {
+	ctx, span := startSpan(ctx, "TokenStore.DeleteAllTokensForUser")
+	defer span.End()
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
 	query := `
   DELETE FROM tokens
   WHERE scope = $1 AND user_id = $2
   `
 
-	_, err := t.db.Exec(query, scope, userID)
+	_, err := t.db.ExecContext(ctx, query, scope, userID)
+	return translateError(err)
+}
+
+// DeleteToken returns ErrNotFound when there was no such token. the rest
+// of the token's family goes with it, so logging out also kills the refresh token
+func (t *PostgresTokenStore) DeleteToken(ctx context.Context, scope, tokenPlainText string) error {
+	ctx, span := startSpan(ctx, "TokenStore.DeleteToken")
+	defer span.End()
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+  DELETE FROM tokens
+  WHERE (hash = $1 AND scope = $2)
+     OR family_id = (SELECT family_id FROM tokens WHERE hash = $1 AND scope = $2)
+  `
+
+	result, err := t.db.ExecContext(ctx, query, tokens.Hash(tokenPlainText), scope)
+	if err != nil {
+		return translateError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return translateError(err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
+
+// DeleteOtherSessions logs the user out everywhere but the current session:
+// every authentication, refresh and pending two-factor token goes, except
+// the current authentication token and the rest of its family
+func (t *PostgresTokenStore) DeleteOtherSessions(ctx context.Context, userID int, currentTokenPlainText string) error {
+	ctx, span := startSpan(ctx, "TokenStore.DeleteOtherSessions")
+	defer span.End()
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+  DELETE FROM tokens
+  WHERE user_id = $1 AND scope = ANY($2) AND hash <> $3
+    AND (family_id IS NULL OR family_id IS DISTINCT FROM (
+      SELECT family_id FROM tokens WHERE hash = $3 AND scope = $4
+    ))
+  `
+
+	_, err := t.db.ExecContext(ctx, query, userID, sessionScopes, tokens.Hash(currentTokenPlainText), tokens.ScopeAuth)
+	return translateError(err)
+}
+
+// DeleteSessions logs the user out everywhere. audit, when given, is
+// written in the same transaction
+func (t *PostgresTokenStore) DeleteSessions(ctx context.Context, userID int, audit *AuditEntry) error {
+	ctx, span := startSpan(ctx, "TokenStore.DeleteSessions")
+	defer span.End()
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = deleteSessions(ctx, tx, userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	if audit != nil {
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return translateError(err)
+		}
+	}
+
+	return translateError(tx.Commit())
+}
+
+func deleteSessions(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1 AND scope = ANY($2)`, userID, sessionScopes)
 	return err
 }
+
+// ListSessions the user's unexpired authentication tokens, most recently used first
+func (t *PostgresTokenStore) ListSessions(ctx context.Context, userID int, currentTokenPlainText string) ([]*Session, error) {
+	ctx, span := startSpan(ctx, "TokenStore.ListSessions")
+	defer span.End()
+	ctx, cancel := t.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+  SELECT id, hash, created_at, expiry, last_used_at, user_agent
+  FROM tokens
+  WHERE user_id = $1 AND scope = $2 AND expiry > $3
+  ORDER BY COALESCE(last_used_at, created_at) DESC
+  `
+
+	rows, err := t.db.QueryContext(ctx, query, userID, tokens.ScopeAuth, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	currentHash := tokens.Hash(currentTokenPlainText)
+	sessions := []*Session{}
+	for rows.Next() {
+		var hash []byte
+		session := &Session{}
+		err = rows.Scan(&session.ID, &hash, &session.CreatedAt, &session.Expiry, &session.LastUsedAt, &session.UserAgent)
+		if err != nil {
+			return nil, err
+		}
+		session.Current = bytes.Equal(hash, currentHash)
+		sessions = append(sessions, session)
+	}
+
+	return sessions, rows.Err()
+}
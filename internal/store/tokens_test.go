@@ -0,0 +1,123 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nickemma/internal/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenSessions(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	tokenStore := NewPostgresTokenStore(db)
+	userStore := NewPostgresUserStore(db)
+	user := createTestUser(t, db, "sessions")
+
+	phone, err := tokens.GenerateToken(user.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+	phone.UserAgent = "ThriveTrack/1.0 iOS"
+	require.NoError(t, tokenStore.Insert(ctx, phone))
+
+	laptop, err := tokenStore.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+
+	found, err := userStore.GetUserToken(ctx, tokens.ScopeAuth, phone.Plaintext)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, found.ID)
+
+	sessions, err := tokenStore.ListSessions(ctx, user.ID, phone.Plaintext)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.True(t, sessions[0].Current)
+	assert.NotNil(t, sessions[0].LastUsedAt)
+	assert.Equal(t, "ThriveTrack/1.0 iOS", sessions[0].UserAgent)
+	assert.False(t, sessions[1].Current)
+
+	require.NoError(t, tokenStore.DeleteToken(ctx, tokens.ScopeAuth, phone.Plaintext))
+	assert.ErrorIs(t, tokenStore.DeleteToken(ctx, tokens.ScopeAuth, phone.Plaintext), ErrNotFound)
+
+	_, err = userStore.GetUserToken(ctx, tokens.ScopeAuth, phone.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, tokenStore.DeleteAllTokensForUser(ctx, user.ID, tokens.ScopeAuth))
+	_, err = userStore.GetUserToken(ctx, tokens.ScopeAuth, laptop.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	tokenStore := NewPostgresTokenStore(db)
+	userStore := NewPostgresUserStore(db)
+	user := createTestUser(t, db, "rotator")
+
+	access, refresh, err := tokenStore.CreateTokenPair(ctx, user.ID, "ThriveTrack/1.0 Android")
+	require.NoError(t, err)
+	assert.Equal(t, access.Family, refresh.Family)
+
+	newAccess, newRefresh, err := tokenStore.RotateRefreshToken(ctx, refresh.Plaintext, "ThriveTrack/1.0 Android")
+	require.NoError(t, err)
+	assert.Equal(t, refresh.Family, newRefresh.Family)
+
+	// the previous access token of the family is gone
+	_, err = userStore.GetUserToken(ctx, tokens.ScopeAuth, access.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	found, err := userStore.GetUserToken(ctx, tokens.ScopeAuth, newAccess.Plaintext)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, found.ID)
+
+	_, _, err = tokenStore.RotateRefreshToken(ctx, refresh.Plaintext, "stolen")
+	assert.ErrorIs(t, err, ErrRefreshTokenReused)
+
+	// reuse revoked the whole family
+	_, err = userStore.GetUserToken(ctx, tokens.ScopeAuth, newAccess.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, _, err = tokenStore.RotateRefreshToken(ctx, newRefresh.Plaintext, "ThriveTrack/1.0 Android")
+	assert.ErrorIs(t, err, ErrInvalidRefreshToken)
+}
+
+func TestDeleteOtherSessions(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	tokenStore := NewPostgresTokenStore(db)
+	userStore := NewPostgresUserStore(db)
+	user := createTestUser(t, db, "changer")
+	other := createTestUser(t, db, "bystander")
+
+	access, refresh, err := tokenStore.CreateTokenPair(ctx, user.ID, "laptop")
+	require.NoError(t, err)
+	stolen, stolenRefresh, err := tokenStore.CreateTokenPair(ctx, user.ID, "unknown")
+	require.NoError(t, err)
+	pending, err := tokenStore.CreateNewToken(ctx, user.ID, time.Minute, tokens.ScopeTwoFactorPending)
+	require.NoError(t, err)
+	otherAccess, err := tokenStore.CreateNewToken(ctx, other.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+
+	require.NoError(t, tokenStore.DeleteOtherSessions(ctx, user.ID, access.Plaintext))
+
+	_, err = userStore.GetUserToken(ctx, tokens.ScopeAuth, access.Plaintext)
+	assert.NoError(t, err)
+	_, err = userStore.GetUserToken(ctx, tokens.ScopeRefresh, refresh.Plaintext)
+	assert.NoError(t, err)
+	_, err = userStore.GetUserToken(ctx, tokens.ScopeAuth, otherAccess.Plaintext)
+	assert.NoError(t, err)
+
+	_, err = userStore.GetUserToken(ctx, tokens.ScopeAuth, stolen.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = userStore.GetUserToken(ctx, tokens.ScopeRefresh, stolenRefresh.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = userStore.GetUserToken(ctx, tokens.ScopeTwoFactorPending, pending.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
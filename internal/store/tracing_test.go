@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestQueryTracer(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	ctx, span := startSpan(context.Background(), "WorkoutStore.UpdateWorkout")
+	qt := queryTracer{}
+
+	queryCtx := qt.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "\n  INSERT INTO workout_entries (workout_id) VALUES ($1)\n"})
+	qt.TraceQueryEnd(queryCtx, nil, pgx.TraceQueryEndData{CommandTag: pgconn.NewCommandTag("INSERT 0 3")})
+
+	queryCtx = qt.TraceQueryStart(ctx, nil, pgx.TraceQueryStartData{SQL: "delete from workouts"})
+	qt.TraceQueryEnd(queryCtx, nil, pgx.TraceQueryEndData{Err: errors.New("deadlock detected")})
+	span.End()
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 3)
+	insert, failed, method := spans[0], spans[1], spans[2]
+
+	assert.Equal(t, "INSERT", insert.Name)
+	assert.Equal(t, method.SpanContext.SpanID(), insert.Parent.SpanID())
+	attrs := map[string]any{}
+	for _, kv := range insert.Attributes {
+		attrs[string(kv.Key)] = kv.Value.AsInterface()
+	}
+	assert.Equal(t, "INSERT INTO workout_entries (workout_id) VALUES ($1)", attrs["db.query.text"])
+	assert.Equal(t, int64(3), attrs["db.response.rows"])
+
+	assert.Equal(t, "DELETE", failed.Name)
+	assert.Equal(t, codes.Error, failed.Status.Code)
+	assert.Equal(t, "WorkoutStore.UpdateWorkout", method.Name)
+}
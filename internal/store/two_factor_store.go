@@ -0,0 +1,292 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/nickemma/internal/tokens"
+	"github.com/nickemma/internal/totp"
+)
+
+// TwoFactor the TOTP state of a user. Secret is empty until the setup was
+// started, Enabled only turns true once a code from it was verified
+type TwoFactor struct {
+	Secret   string
+	Enabled  bool
+	LastStep *int64
+}
+
+var (
+	ErrTwoFactorEnabled    = newStoreError(ErrConflict, "two-factor authentication is already enabled")
+	ErrTOTPCodeUsed        = newStoreError(ErrConflict, "the code was already used")
+	ErrTwoFactorNotEnabled = newStoreError(ErrConflict, "two-factor authentication is not enabled")
+)
+
+type PostgresTwoFactorStore struct {
+	db *sql.DB
+	queryTimeout
+}
+
+func NewPostgresTwoFactorStore(db *sql.DB) *PostgresTwoFactorStore {
+	return &PostgresTwoFactorStore{db: db, queryTimeout: queryTimeout{timeout: DefaultQueryTimeout}}
+}
+
+type TwoFactorStore interface {
+	GetTwoFactor(ctx context.Context, userID int) (*TwoFactor, error)
+	SetTOTPSecret(ctx context.Context, userID int, secret string) error
+	EnableTwoFactor(ctx context.Context, userID int, step int64, recoveryCodes []string) error
+	UseTOTPStep(ctx context.Context, userID int, step int64) error
+	UseRecoveryCode(ctx context.Context, userID int, code string) error
+	ReplaceRecoveryCodes(ctx context.Context, userID int, recoveryCodes []string) error
+	DisableTwoFactor(ctx context.Context, userID int) error
+}
+
+// GetTwoFactor returns ErrNotFound when the user doesn't exist
+func (s *PostgresTwoFactorStore) GetTwoFactor(ctx context.Context, userID int) (*TwoFactor, error) {
+	ctx, span := startSpan(ctx, "TwoFactorStore.GetTwoFactor")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+  SELECT COALESCE(totp_secret, ''), two_factor_enabled, totp_last_step
+  FROM users
+  WHERE id = $1
+  `
+
+	twoFactor := &TwoFactor{}
+	err := s.db.QueryRowContext(ctx, query, userID).Scan(&twoFactor.Secret, &twoFactor.Enabled, &twoFactor.LastStep)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return twoFactor, nil
+}
+
+// SetTOTPSecret starts the setup, a setup that was never verified is simply
+// replaced. ErrTwoFactorEnabled once it is enabled
+func (s *PostgresTwoFactorStore) SetTOTPSecret(ctx context.Context, userID int, secret string) error {
+	ctx, span := startSpan(ctx, "TwoFactorStore.SetTOTPSecret")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+  UPDATE users
+  SET totp_secret = $2, totp_last_step = NULL, updated_at = CURRENT_TIMESTAMP
+  WHERE id = $1 AND NOT two_factor_enabled
+  `
+
+	result, err := s.db.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return translateError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return translateError(err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrTwoFactorEnabled
+	}
+
+	return nil
+}
+
+// EnableTwoFactor turns 2FA on after the code of step was verified and
+// replaces the recovery codes, only their hashes are stored
+func (s *PostgresTwoFactorStore) EnableTwoFactor(ctx context.Context, userID int, step int64, recoveryCodes []string) error {
+	ctx, span := startSpan(ctx, "TwoFactorStore.EnableTwoFactor")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	query := `
+  UPDATE users
+  SET two_factor_enabled = TRUE, totp_last_step = $2, updated_at = CURRENT_TIMESTAMP
+  WHERE id = $1 AND NOT two_factor_enabled AND totp_secret IS NOT NULL
+  `
+
+	result, err := tx.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return translateError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return translateError(err)
+	}
+
+	// a concurrent verify got there first
+	if rowsAffected == 0 {
+		return ErrTwoFactorEnabled
+	}
+
+	err = insertRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return translateError(tx.Commit())
+}
+
+// insertRecoveryCodes replaces every recovery code of the user, used or not
+func insertRecoveryCodes(ctx context.Context, tx *sql.Tx, userID int, recoveryCodes []string) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range recoveryCodes {
+		_, err = tx.ExecContext(ctx, `INSERT INTO recovery_codes (user_id, hash) VALUES ($1, $2)`, userID, tokens.Hash(code))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceRecoveryCodes a new set of recovery codes, the old ones stop
+// working. ErrTwoFactorNotEnabled unless 2FA is enabled
+func (s *PostgresTwoFactorStore) ReplaceRecoveryCodes(ctx context.Context, userID int, recoveryCodes []string) error {
+	ctx, span := startSpan(ctx, "TwoFactorStore.ReplaceRecoveryCodes")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	// the lock keeps a concurrent disable from leaving codes behind
+	var enabled bool
+	err = tx.QueryRowContext(ctx, `SELECT two_factor_enabled FROM users WHERE id = $1 FOR UPDATE`, userID).Scan(&enabled)
+	if err != nil {
+		return translateError(err)
+	}
+	if !enabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	err = insertRecoveryCodes(ctx, tx, userID, recoveryCodes)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return translateError(tx.Commit())
+}
+
+// DisableTwoFactor turns 2FA off and forgets the secret and the recovery
+// codes, turning it on again starts from a new setup. ErrTwoFactorNotEnabled when
+// it wasn't enabled
+func (s *PostgresTwoFactorStore) DisableTwoFactor(ctx context.Context, userID int) error {
+	ctx, span := startSpan(ctx, "TwoFactorStore.DisableTwoFactor")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return translateError(err)
+	}
+	defer tx.Rollback()
+
+	query := `
+  UPDATE users
+  SET two_factor_enabled = FALSE, totp_secret = NULL, totp_last_step = NULL, updated_at = CURRENT_TIMESTAMP
+  WHERE id = $1 AND two_factor_enabled
+  `
+
+	result, err := tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return translateError(err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrTwoFactorNotEnabled
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return translateError(tx.Commit())
+}
+
+// UseTOTPStep records that the code of step was used, ErrTOTPCodeUsed when
+// it or a later one already was, so an intercepted code can't be replayed
+func (s *PostgresTwoFactorStore) UseTOTPStep(ctx context.Context, userID int, step int64) error {
+	ctx, span := startSpan(ctx, "TwoFactorStore.UseTOTPStep")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+  UPDATE users
+  SET totp_last_step = $2
+  WHERE id = $1 AND (totp_last_step IS NULL OR totp_last_step < $2)
+  `
+
+	result, err := s.db.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return translateError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return translateError(err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrTOTPCodeUsed
+	}
+
+	return nil
+}
+
+// UseRecoveryCode spends a recovery code, ErrNotFound when it is unknown or
+// was used before
+func (s *PostgresTwoFactorStore) UseRecoveryCode(ctx context.Context, userID int, code string) error {
+	ctx, span := startSpan(ctx, "TwoFactorStore.UseRecoveryCode")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+  UPDATE recovery_codes
+  SET used_at = $3
+  WHERE user_id = $1 AND hash = $2 AND used_at IS NULL
+  `
+
+	result, err := s.db.ExecContext(ctx, query, userID, tokens.Hash(totp.NormalizeRecoveryCode(code)), time.Now())
+	if err != nil {
+		return translateError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return translateError(err)
+	}
+
+	if rowsAffected == 0 {
+		return ErrNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTwoFactor(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	twoFactorStore := NewPostgresTwoFactorStore(db)
+	userStore := NewPostgresUserStore(db)
+	user := createTestUser(t, db, "careful")
+
+	twoFactor, err := twoFactorStore.GetTwoFactor(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Empty(t, twoFactor.Secret)
+	assert.False(t, twoFactor.Enabled)
+
+	// an unverified setup can be started over
+	require.NoError(t, twoFactorStore.SetTOTPSecret(ctx, user.ID, "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, twoFactorStore.SetTOTPSecret(ctx, user.ID, "KRSXG5CTMVRXEZLU"))
+
+	require.NoError(t, twoFactorStore.EnableTwoFactor(ctx, user.ID, 100, []string{"AAAAA-BBBBB", "CCCCC-DDDDD"}))
+	assert.ErrorIs(t, twoFactorStore.EnableTwoFactor(ctx, user.ID, 101, nil), ErrTwoFactorEnabled)
+	assert.ErrorIs(t, twoFactorStore.SetTOTPSecret(ctx, user.ID, "JBSWY3DPEHPK3PXP"), ErrTwoFactorEnabled)
+
+	user, err = userStore.GetUserByUsername(ctx, "careful")
+	require.NoError(t, err)
+	assert.True(t, user.TwoFactorEnabled)
+
+	twoFactor, err = twoFactorStore.GetTwoFactor(ctx, user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "KRSXG5CTMVRXEZLU", twoFactor.Secret)
+
+	// the step of the verifying code, and anything before it, is spent
+	assert.ErrorIs(t, twoFactorStore.UseTOTPStep(ctx, user.ID, 100), ErrTOTPCodeUsed)
+	require.NoError(t, twoFactorStore.UseTOTPStep(ctx, user.ID, 101))
+	assert.ErrorIs(t, twoFactorStore.UseTOTPStep(ctx, user.ID, 101), ErrTOTPCodeUsed)
+	assert.ErrorIs(t, twoFactorStore.UseTOTPStep(ctx, user.ID, 99), ErrTOTPCodeUsed)
+
+	require.NoError(t, twoFactorStore.UseRecoveryCode(ctx, user.ID, "aaaaa bbbbb"))
+	assert.ErrorIs(t, twoFactorStore.UseRecoveryCode(ctx, user.ID, "AAAAA-BBBBB"), ErrNotFound)
+	assert.ErrorIs(t, twoFactorStore.UseRecoveryCode(ctx, user.ID, "EEEEE-FFFFF"), ErrNotFound)
+	require.NoError(t, twoFactorStore.UseRecoveryCode(ctx, user.ID, "CCCCC-DDDDD"))
+
+	// used up codes are replaced, the old ones are gone for good
+	require.NoError(t, twoFactorStore.ReplaceRecoveryCodes(ctx, user.ID, []string{"GGGGG-HHHHH"}))
+	assert.ErrorIs(t, twoFactorStore.UseRecoveryCode(ctx, user.ID, "CCCCC-DDDDD"), ErrNotFound)
+
+	require.NoError(t, twoFactorStore.DisableTwoFactor(ctx, user.ID))
+	assert.ErrorIs(t, twoFactorStore.DisableTwoFactor(ctx, user.ID), ErrTwoFactorNotEnabled)
+	assert.ErrorIs(t, twoFactorStore.ReplaceRecoveryCodes(ctx, user.ID, []string{"IIIII-JJJJJ"}), ErrTwoFactorNotEnabled)
+	assert.ErrorIs(t, twoFactorStore.UseRecoveryCode(ctx, user.ID, "GGGGG-HHHHH"), ErrNotFound)
+
+	twoFactor, err = twoFactorStore.GetTwoFactor(ctx, user.ID)
+	require.NoError(t, err)
+	assert.False(t, twoFactor.Enabled)
+	assert.Empty(t, twoFactor.Secret)
+
+	// and can be set up again from scratch
+	require.NoError(t, twoFactorStore.SetTOTPSecret(ctx, user.ID, "JBSWY3DPEHPK3PXP"))
+}
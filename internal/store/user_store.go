@@ -1,21 +1,34 @@
 package store
 
 import (
-	"crypto/sha256"
+	"context"
 	"database/sql"
+	"encoding/base64"
 	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nickemma/internal/tokens"
+	"github.com/nickemma/internal/validator"
 	"golang.org/x/crypto/bcrypt"
 )
 
+// BcryptCost the work factor for new password hashes, existing hashes keep
+// the cost they were created with
+var BcryptCost = 12
+
 type password struct {
 	plaintText *string
 	hash       []byte
 }
 
 func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), BcryptCost)
 	if err != nil {
 		return err
 	}
@@ -39,77 +52,260 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// CompareDummyPassword costs as much as checking a real password and never
+// matches. logins for unknown usernames go through it, so they can't be told
+// apart from wrong passwords by how long they take
+func CompareDummyPassword(plaintextPassword string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("not the password of any account"), BcryptCost)
+	})
+	_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(plaintextPassword))
+}
+
 type User struct { // LOGGED IN USER
 	ID           int       `json:"id"`
 	Username     string    `json:"username"`
 	Email        string    `json:"email"`
 	PasswordHash password  `json:"-"`
 	Bio          string    `json:"bio"`
+	Activated    bool      `json:"activated"`
 	CreatedAt    time.Time `json:"created_at"`
 	UpdatedAt    time.Time `json:"updated_at"`
+
+	TwoFactorEnabled bool       `json:"two_factor_enabled"`
+	Role             string     `json:"role"`
+	Permissions      []string   `json:"permissions"`
+	DisabledAt       *time.Time `json:"disabled_at,omitempty"`
+
+	FailedLoginAttempts int        `json:"-"`
+	LockedUntil         *time.Time `json:"-"`
+}
+
+// HasPermission reports whether the role of the user grants permission
+func (u *User) HasPermission(permission string) bool {
+	return slices.Contains(u.Permissions, permission)
+}
+
+// IsDisabled an admin turned the account off
+func (u *User) IsDisabled() bool {
+	return u.DisabledAt != nil
+}
+
+// IsLocked reports whether logins are refused until LockedUntil
+func (u *User) IsLocked(now time.Time) bool {
+	return u.LockedUntil != nil && u.LockedUntil.After(now)
+}
+
+// LockoutPolicy after Threshold failed logins in a row the account is locked
+// for BaseDuration, doubling with every further failure up to MaxDuration.
+// a zero Threshold turns the lockout off
+type LockoutPolicy struct {
+	Threshold    int
+	BaseDuration time.Duration
+	MaxDuration  time.Duration
 }
 
+var DefaultLockoutPolicy = LockoutPolicy{Threshold: 5, BaseDuration: time.Minute, MaxDuration: time.Hour}
+
+// the roles of the roles table, RoleUser is the default of new accounts
+const (
+	RoleUser  = "user"
+	RoleCoach = "coach"
+	RoleAdmin = "admin"
+)
+
+var Roles = []string{RoleUser, RoleCoach, RoleAdmin}
+
+// the permissions granted through role_permissions, checked with RequirePermission
+const (
+	PermissionUsersRead       = "users:read"
+	PermissionUsersManage     = "users:manage"
+	PermissionWorkoutsReadAny = "workouts:read-any"
+	PermissionAuditRead       = "audit:read"
+	PermissionCoachAthletes   = "athletes:coach"
+)
+
 var AnonymousUser = &User{} // EVERYONE WHOS NOT LOGGED IN
 
 func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
+var (
+	ErrDuplicateUsername = newStoreError(ErrDuplicate, "a user with this username already exists")
+	ErrDuplicateEmail    = newStoreError(ErrDuplicate, "a user with this email already exists")
+)
+
+// uniqueUserError maps unique violations on users to the field that clashed
+func uniqueUserError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		switch pgErr.ConstraintName {
+		case "users_username_key":
+			return ErrDuplicateUsername
+		case "users_email_key":
+			return ErrDuplicateEmail
+		}
+	}
+	return translateError(err)
+}
+
+func ValidateUsername(v *validator.Validator, username string) {
+	v.Check(username != "", "username", "must be provided")
+	v.Check(len(username) <= 50, "username", "must not be more than 50 bytes long")
+}
+
+func ValidateEmail(v *validator.Validator, email string) {
+	v.Check(email != "", "email", "must be provided")
+	v.Check(len(email) <= 255, "email", "must not be more than 255 bytes long")
+	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
+}
+
+// ValidatePasswordPlaintext bcrypt ignores everything after 72 bytes
+func ValidatePasswordPlaintext(v *validator.Validator, key, password string) {
+	v.Check(password != "", key, "must be provided")
+	v.Check(len(password) >= 8, key, "must be at least 8 bytes long")
+	v.Check(len(password) <= 72, key, "must not be more than 72 bytes long")
+}
+
+// userColumns the columns scanUser reads, of the users table aliased u.
+// the permissions of the role come along as a comma separated list
+const userColumns = `u.id, u.username, u.email, u.password_hash, u.bio, u.activated, u.two_factor_enabled,
+  u.role, u.disabled_at, u.failed_login_attempts, u.locked_until, u.created_at, u.updated_at,
+  COALESCE((SELECT string_agg(rp.permission, ',' ORDER BY rp.permission) FROM role_permissions rp WHERE rp.role = u.role), '')`
+
+// rowScanner a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row rowScanner) (*User, error) {
+	user := &User{
+		PasswordHash: password{},
+	}
+
+	var permissions string
+	err := row.Scan(
+		&user.ID,
+		&user.Username,
+		&user.Email,
+		&user.PasswordHash.hash,
+		&user.Bio,
+		&user.Activated,
+		&user.TwoFactorEnabled,
+		&user.Role,
+		&user.DisabledAt,
+		&user.FailedLoginAttempts,
+		&user.LockedUntil,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+		&permissions,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	user.Permissions = []string{}
+	if permissions != "" {
+		user.Permissions = strings.Split(permissions, ",")
+	}
+	return user, nil
+}
+
 type PostgresUserStore struct {
 	db *sql.DB
+	queryTimeout
+
+	lockout LockoutPolicy
 }
 
 func NewPostgresUserStore(db *sql.DB) *PostgresUserStore {
 	return &PostgresUserStore{
-		db: db,
+		db:           db,
+		queryTimeout: queryTimeout{timeout: DefaultQueryTimeout},
+		lockout:      DefaultLockoutPolicy,
 	}
 }
 
+func (s *PostgresUserStore) SetLockoutPolicy(policy LockoutPolicy) {
+	s.lockout = policy
+}
+
 type UserStore interface {
-	CreateUser(*User) error
-	GetUserByUsername(username string) (*User, error)
-	UpdateUser(*User) error
-	GetUserToken(scope, tokenPlainText string) (*User, error)
+	CreateUser(ctx context.Context, user *User) error
+	GetUserByUsername(ctx context.Context, username string) (*User, error)
+	GetUserByEmail(ctx context.Context, email string) (*User, error)
+	UpdateUser(ctx context.Context, user *User) error
+	UpdatePassword(ctx context.Context, user *User) error
+	DeleteUser(ctx context.Context, id int) error
+	GetUserToken(ctx context.Context, scope, tokenPlainText string) (*User, error)
+	RecordFailedLogin(ctx context.Context, id int) (*time.Time, error)
+	ResetFailedLogins(ctx context.Context, id int) error
+	GetUserByID(ctx context.Context, id int) (*User, error)
+	ListUsers(ctx context.Context, filter UserFilter) ([]*User, string, error)
+	SetDisabled(ctx context.Context, id int, disabled bool, audit *AuditEntry) (*User, error)
+	SetRole(ctx context.Context, id int, role string, audit *AuditEntry) (*User, error)
 }
 
-func (s *PostgresUserStore) CreateUser(user *User) error {
+func (s *PostgresUserStore) CreateUser(ctx context.Context, user *User) error {
+	ctx, span := startSpan(ctx, "UserStore.CreateUser")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	query := `
   INSERT INTO users (username, email, password_hash, bio)
   VALUES ($1, $2, $3, $4)
-  RETURNING id, created_at, updated_at
+  RETURNING id, activated, role, created_at, updated_at
   `
 
-	err := s.db.QueryRow(query, user.Username, user.Email, user.PasswordHash.hash, user.Bio).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	err := s.db.QueryRowContext(ctx, query, user.Username, user.Email, user.PasswordHash.hash, user.Bio).Scan(&user.ID, &user.Activated, &user.Role, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
-		return err
+		return uniqueUserError(err)
 	}
+	// the default role grants nothing beyond the user's own data
+	user.Permissions = []string{}
 
 	return nil
 }
 
-func (s *PostgresUserStore) GetUserByUsername(username string) (*User, error) {
-	user := &User{
-		PasswordHash: password{},
+func (s *PostgresUserStore) GetUserByUsername(ctx context.Context, username string) (*User, error) {
+	ctx, span := startSpan(ctx, "UserStore.GetUserByUsername")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users u WHERE u.username = $1`
+
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, username))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
 	}
 
-	query := `
-  SELECT id, username, email, password_hash, bio, created_at, updated_at
-  FROM users
-  WHERE username = $1
-  `
+	if err != nil {
+		return nil, err
+	}
 
-	err := s.db.QueryRow(query, username).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.PasswordHash.hash,
-		&user.Bio,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	return user, nil
+}
+
+// GetUserByEmail emails are matched case-insensitively
+func (s *PostgresUserStore) GetUserByEmail(ctx context.Context, email string) (*User, error) {
+	ctx, span := startSpan(ctx, "UserStore.GetUserByEmail")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users u WHERE LOWER(u.email) = LOWER($1)`
 
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, email))
 	if err == sql.ErrNoRows {
-		return nil, nil
+		return nil, ErrNotFound
 	}
 
 	if err != nil {
@@ -119,62 +315,373 @@ func (s *PostgresUserStore) GetUserByUsername(username string) (*User, error) {
 	return user, nil
 }
 
-func (s *PostgresUserStore) UpdateUser(user *User) error {
+func (s *PostgresUserStore) GetUserByID(ctx context.Context, id int) (*User, error) {
+	ctx, span := startSpan(ctx, "UserStore.GetUserByID")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT ` + userColumns + ` FROM users u WHERE u.id = $1`
+
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, id))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// UpdateUser returns ErrNotFound when the user doesn't exist
+func (s *PostgresUserStore) UpdateUser(ctx context.Context, user *User) error {
+	ctx, span := startSpan(ctx, "UserStore.UpdateUser")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	query := `
   UPDATE users
-  SET username = $1, email = $2, bio = $3, updated_at = CURRENT_TIMESTAMP
-  WHERE id = $4
+  SET username = $1, email = $2, bio = $3, activated = $4, updated_at = CURRENT_TIMESTAMP
+  WHERE id = $5
   RETURNING updated_at
   `
 
-	result, err := s.db.Exec(query, user.Username, user.Email, user.Bio, user.ID)
+	err := s.db.QueryRowContext(ctx, query, user.Username, user.Email, user.Bio, user.Activated, user.ID).Scan(&user.UpdatedAt)
 	if err != nil {
-		return err
+		return uniqueUserError(err)
+	}
+
+	return nil
+}
+
+// UpdatePassword stores the hash set with PasswordHash.Set, a new password
+// also lifts the lockout
+func (s *PostgresUserStore) UpdatePassword(ctx context.Context, user *User) error {
+	ctx, span := startSpan(ctx, "UserStore.UpdatePassword")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+  UPDATE users
+  SET password_hash = $1, failed_login_attempts = 0, locked_until = NULL, updated_at = CURRENT_TIMESTAMP
+  WHERE id = $2
+  RETURNING updated_at
+  `
+
+	err := s.db.QueryRowContext(ctx, query, user.PasswordHash.hash, user.ID).Scan(&user.UpdatedAt)
+	if err != nil {
+		return translateError(err)
+	}
+
+	return nil
+}
+
+// DeleteUser workouts, templates, records and tokens of the user go with it
+func (s *PostgresUserStore) DeleteUser(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "UserStore.DeleteUser")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	result, err := s.db.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return translateError(err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil {
-		return err
+		return translateError(err)
 	}
 
 	if rowsAffected == 0 {
-		return sql.ErrNoRows
+		return ErrNotFound
 	}
 
 	return nil
 }
 
-func (s *PostgresUserStore) GetUserToken(scope, plaintextPassword string) (*User, error) {
-	tokenHash := sha256.Sum256([]byte(plaintextPassword))
+// GetUserToken looks up the owner of a valid token and records that the
+// token was used, ErrNotFound when the token is unknown or expired or the
+// account is disabled
+func (s *PostgresUserStore) GetUserToken(ctx context.Context, scope, plaintextPassword string) (*User, error) {
+	ctx, span := startSpan(ctx, "UserStore.GetUserToken")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
 	query := `
-  SELECT u.id, u.username, u.email, u.password_hash, u.bio, u.created_at, u.updated_at
+  WITH used AS (
+    UPDATE tokens SET last_used_at = CURRENT_TIMESTAMP
+    WHERE hash = $1 AND scope = $2 AND expiry > $3
+    RETURNING user_id
+  )
+  SELECT ` + userColumns + `
   FROM users u
-  INNER JOIN tokens t ON t.user_id = u.id
-  WHERE t.hash = $1 AND t.scope = $2 and t.expiry > $3
+  INNER JOIN used t ON t.user_id = u.id
+  WHERE u.disabled_at IS NULL
   `
 
-	user := &User{
-		PasswordHash: password{},
+	user, err := scanUser(s.db.QueryRowContext(ctx, query, tokens.Hash(plaintextPassword), scope, time.Now()))
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
 	}
 
-	err := s.db.QueryRow(query, tokenHash[:], scope, time.Now()).Scan(
-		&user.ID,
-		&user.Username,
-		&user.Email,
-		&user.PasswordHash.hash,
-		&user.Bio,
-		&user.CreatedAt,
-		&user.UpdatedAt,
-	)
+	if err != nil {
+		return nil, err
+	}
 
-	if err == sql.ErrNoRows {
-		return nil, nil
+	return user, nil
+}
+
+// RecordFailedLogin counts a wrong password and locks the account once the
+// lockout threshold is reached, returns the end of the lock or nil
+func (s *PostgresUserStore) RecordFailedLogin(ctx context.Context, id int) (*time.Time, error) {
+	ctx, span := startSpan(ctx, "UserStore.RecordFailedLogin")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	// the lock doubles for every failure past the threshold, the exponent is
+	// capped so POWER can't overflow on accounts under a long attack
+	query := `
+  UPDATE users
+  SET failed_login_attempts = failed_login_attempts + 1,
+    locked_until = CASE
+      WHEN $2 > 0 AND failed_login_attempts + 1 >= $2
+      THEN NOW() + make_interval(secs => LEAST($4::float8, $3::float8 * POWER(2, LEAST(failed_login_attempts + 1 - $2, 30))))
+      ELSE locked_until
+    END
+  WHERE id = $1
+  RETURNING locked_until
+  `
+
+	var lockedUntil *time.Time
+	err := s.db.QueryRowContext(ctx, query, id, s.lockout.Threshold, s.lockout.BaseDuration.Seconds(), s.lockout.MaxDuration.Seconds()).Scan(&lockedUntil)
+	if err != nil {
+		return nil, translateError(err)
 	}
 
+	return lockedUntil, nil
+}
+
+// ResetFailedLogins after a successful login or a password reset
+func (s *PostgresUserStore) ResetFailedLogins(ctx context.Context, id int) error {
+	ctx, span := startSpan(ctx, "UserStore.ResetFailedLogins")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	_, err := s.db.ExecContext(ctx, `UPDATE users SET failed_login_attempts = 0, locked_until = NULL WHERE id = $1`, id)
+	return translateError(err)
+}
+
+const (
+	DefaultUserListLimit = 50
+	MaxUserListLimit     = 200
+)
+
+// UserFilter narrows down the user list of the admin endpoints, empty
+// values and nil pointers don't filter
+type UserFilter struct {
+	Search   string // case insensitive substring of the username or email
+	Role     string
+	Disabled *bool
+	Limit    int
+	Cursor   string
+}
+
+// encodeIDCursor the cursor of lists that page by id
+func encodeIDCursor(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
+}
+
+func decodeIDCursor(cursor string) (int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	id, err := strconv.Atoi(string(b))
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
+
+// ListUsers a page of users ordered by id and the cursor of the next page,
+// the cursor is empty on the last page
+func (s *PostgresUserStore) ListUsers(ctx context.Context, filter UserFilter) ([]*User, string, error) {
+	ctx, span := startSpan(ctx, "UserStore.ListUsers")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultUserListLimit
+	}
+	if filter.Limit > MaxUserListLimit {
+		filter.Limit = MaxUserListLimit
+	}
+
+	conditions := []string{"TRUE"}
+	args := []any{}
+	addCondition := func(format string, values ...any) {
+		placeholders := make([]any, len(values))
+		for i, v := range values {
+			args = append(args, v)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		conditions = append(conditions, fmt.Sprintf(format, placeholders...))
+	}
+
+	if filter.Search != "" {
+		addCondition("(u.username ILIKE '%%' || %[1]s || '%%' OR u.email ILIKE '%%' || %[1]s || '%%')", escapeLike(filter.Search))
+	}
+	if filter.Role != "" {
+		addCondition("u.role = %s", filter.Role)
+	}
+	if filter.Disabled != nil {
+		addCondition("(u.disabled_at IS NOT NULL) = %s", *filter.Disabled)
+	}
+	if filter.Cursor != "" {
+		afterID, err := decodeIDCursor(filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		addCondition("u.id > %s", afterID)
+	}
+
+	// one row more than the page tells whether there is a next page
+	args = append(args, filter.Limit+1)
+	query := fmt.Sprintf(`SELECT %s FROM users u WHERE %s ORDER BY u.id LIMIT $%d`,
+		userColumns, strings.Join(conditions, " AND "), len(args))
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, "", err
+		}
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(users) > filter.Limit {
+		users = users[:filter.Limit]
+		nextCursor = encodeIDCursor(users[len(users)-1].ID)
+	}
+
+	return users, nextCursor, nil
+}
+
+// SetDisabled turns an account off or back on, returns ErrNotFound when the
+// user doesn't exist. disabling also logs the account out everywhere. audit,
+// when given, is written in the same transaction
+func (s *PostgresUserStore) SetDisabled(ctx context.Context, id int, disabled bool, audit *AuditEntry) (*User, error) {
+	ctx, span := startSpan(ctx, "UserStore.SetDisabled")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
+	defer tx.Rollback()
 
-	return user, nil
+	// disabling twice keeps the time it was first disabled
+	query := `
+  UPDATE users
+  SET disabled_at = CASE WHEN $2 THEN COALESCE(disabled_at, NOW()) END, updated_at = CURRENT_TIMESTAMP
+  WHERE id = $1
+  `
+
+	result, err := tx.ExecContext(ctx, query, id, disabled)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	if rowsAffected == 0 {
+		return nil, ErrNotFound
+	}
+
+	if disabled {
+		err = deleteSessions(ctx, tx, id)
+		if err != nil {
+			return nil, translateError(err)
+		}
+	}
+
+	if audit != nil {
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return nil, translateError(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return s.GetUserByID(ctx, id)
+}
+
+// SetRole returns ErrNotFound when the user doesn't exist, the role must be
+// one of Roles. audit, when given, gets the old and new role as details and
+// is written in the same transaction
+func (s *PostgresUserStore) SetRole(ctx context.Context, id int, role string, audit *AuditEntry) (*User, error) {
+	ctx, span := startSpan(ctx, "UserStore.SetRole")
+	defer span.End()
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var previous string
+	err = tx.QueryRowContext(ctx, `SELECT role FROM users WHERE id = $1 FOR UPDATE`, id).Scan(&previous)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET role = $2, updated_at = CURRENT_TIMESTAMP WHERE id = $1`, id, role)
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	if audit != nil {
+		audit.Details = map[string]any{"from": previous, "to": role}
+		err = insertAuditEntry(ctx, tx, audit)
+		if err != nil {
+			return nil, translateError(err)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, translateError(err)
+	}
+
+	return s.GetUserByID(ctx, id)
 }
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nickemma/internal/tokens"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUpdatePassword(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	userStore := NewPostgresUserStore(db)
+	createTestUser(t, db, "forgetful")
+
+	user, err := userStore.GetUserByEmail(ctx, "Forgetful@Example.com")
+	require.NoError(t, err)
+	require.NotNil(t, user)
+
+	require.NoError(t, user.PasswordHash.Set("new-password"))
+	require.NoError(t, userStore.UpdatePassword(ctx, user))
+
+	user, err = userStore.GetUserByUsername(ctx, "forgetful")
+	require.NoError(t, err)
+	matches, err := user.PasswordHash.Matches("new-password")
+	require.NoError(t, err)
+	assert.True(t, matches)
+
+	_, err = userStore.GetUserByEmail(ctx, "nobody@example.com")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestActivateUser(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	userStore := NewPostgresUserStore(db)
+	user := createTestUser(t, db, "newcomer")
+	assert.False(t, user.Activated)
+
+	user.Activated = true
+	require.NoError(t, userStore.UpdateUser(ctx, user))
+
+	user, err := userStore.GetUserByUsername(ctx, "newcomer")
+	require.NoError(t, err)
+	assert.True(t, user.Activated)
+}
+
+func TestUserProfile(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	userStore := NewPostgresUserStore(db)
+	tokenStore := NewPostgresTokenStore(db)
+	workoutStore := NewPostgresWorkoutStore(db)
+	user := createTestUser(t, db, "leaver")
+	createTestUser(t, db, "stayer")
+
+	user.Email = "stayer@example.com"
+	assert.ErrorIs(t, userStore.UpdateUser(ctx, user), ErrDuplicateEmail)
+	user.Email, user.Username = "leaver@example.com", "stayer"
+	assert.ErrorIs(t, userStore.UpdateUser(ctx, user), ErrDuplicateUsername)
+
+	workout, err := workoutStore.CreateWorkout(ctx, &Workout{UserID: user.ID, Title: "last one", DurationMinutes: 10})
+	require.NoError(t, err)
+	token, err := tokenStore.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+
+	require.NoError(t, userStore.DeleteUser(ctx, user.ID))
+	assert.ErrorIs(t, userStore.DeleteUser(ctx, user.ID), ErrNotFound)
+
+	_, err = workoutStore.GetWorkoutByID(ctx, int64(workout.ID), user.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = userStore.GetUserToken(ctx, tokens.ScopeAuth, token.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestLoginLockout(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	userStore := NewPostgresUserStore(db)
+	userStore.SetLockoutPolicy(LockoutPolicy{Threshold: 3, BaseDuration: time.Minute, MaxDuration: 3 * time.Minute})
+	user := createTestUser(t, db, "guessed")
+
+	for i := 0; i < 2; i++ {
+		lockedUntil, err := userStore.RecordFailedLogin(ctx, user.ID)
+		require.NoError(t, err)
+		assert.Nil(t, lockedUntil)
+	}
+
+	// the third failure locks for the base duration, then it doubles up to the max
+	for _, want := range []time.Duration{time.Minute, 2 * time.Minute, 3 * time.Minute} {
+		lockedUntil, err := userStore.RecordFailedLogin(ctx, user.ID)
+		require.NoError(t, err)
+		require.NotNil(t, lockedUntil)
+		assert.WithinDuration(t, time.Now().Add(want), *lockedUntil, 5*time.Second)
+	}
+
+	user, err := userStore.GetUserByUsername(ctx, "guessed")
+	require.NoError(t, err)
+	assert.Equal(t, 5, user.FailedLoginAttempts)
+	assert.True(t, user.IsLocked(time.Now()))
+
+	require.NoError(t, userStore.ResetFailedLogins(ctx, user.ID))
+	user, err = userStore.GetUserByUsername(ctx, "guessed")
+	require.NoError(t, err)
+	assert.Zero(t, user.FailedLoginAttempts)
+	assert.False(t, user.IsLocked(time.Now()))
+}
+
+func TestRolesAndDisabling(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	userStore := NewPostgresUserStore(db)
+	tokenStore := NewPostgresTokenStore(db)
+	user := createTestUser(t, db, "member")
+	assert.Equal(t, RoleUser, user.Role)
+	assert.Empty(t, user.Permissions)
+
+	user, err := userStore.SetRole(ctx, user.ID, RoleAdmin, nil)
+	require.NoError(t, err)
+	assert.True(t, user.HasPermission(PermissionUsersRead))
+	assert.True(t, user.HasPermission(PermissionAuditRead))
+
+	_, err = userStore.SetRole(ctx, user.ID, "superuser", &AuditEntry{ActorID: user.ID, Action: AuditUserSetRole})
+	assert.ErrorIs(t, err, ErrConflict, "unknown roles break the foreign key")
+	_, err = userStore.SetRole(ctx, user.ID+1000, RoleCoach, nil)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	token, err := tokenStore.CreateNewToken(ctx, user.ID, time.Hour, tokens.ScopeAuth)
+	require.NoError(t, err)
+
+	user, err = userStore.SetDisabled(ctx, user.ID, true, &AuditEntry{ActorID: user.ID, Action: AuditUserDisable, TargetType: "user"})
+	require.NoError(t, err)
+	assert.True(t, user.IsDisabled())
+
+	// disabling logs the account out, enabling it again doesn't bring the session back
+	_, err = userStore.GetUserToken(ctx, tokens.ScopeAuth, token.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// only the change that went through left an entry, the failed role change didn't
+	entries, _, err := NewPostgresAuditStore(db).ListAudit(ctx, AuditFilter{ActorID: user.ID})
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, AuditUserDisable, entries[0].Action)
+
+	user, err = userStore.SetDisabled(ctx, user.ID, false, nil)
+	require.NoError(t, err)
+	assert.False(t, user.IsDisabled())
+	_, err = userStore.GetUserToken(ctx, tokens.ScopeAuth, token.Plaintext)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestListUsers(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	userStore := NewPostgresUserStore(db)
+	for _, name := range []string{"alice", "alicia", "bob", "malice"} {
+		createTestUser(t, db, name)
+	}
+	bob, err := userStore.GetUserByUsername(ctx, "bob")
+	require.NoError(t, err)
+	_, err = userStore.SetDisabled(ctx, bob.ID, true, nil)
+	require.NoError(t, err)
+
+	users, next, err := userStore.ListUsers(ctx, UserFilter{Search: "ALIC", Limit: 2})
+	require.NoError(t, err)
+	require.Len(t, users, 2)
+	assert.Equal(t, "alice", users[0].Username)
+	assert.Equal(t, "alicia", users[1].Username)
+	require.NotEmpty(t, next)
+
+	users, next, err = userStore.ListUsers(ctx, UserFilter{Search: "ALIC", Limit: 2, Cursor: next})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "malice", users[0].Username)
+	assert.Empty(t, next)
+
+	disabled := true
+	users, _, err = userStore.ListUsers(ctx, UserFilter{Disabled: &disabled})
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	assert.Equal(t, "bob", users[0].Username)
+
+	_, _, err = userStore.ListUsers(ctx, UserFilter{Cursor: "not a cursor"})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/nickemma/internal/validator"
+)
+
+// EntryComment a note on one entry of a workout, left by the owner or one
+// of their coaches
+type EntryComment struct {
+	ID             int       `json:"id"`
+	WorkoutEntryID int       `json:"workout_entry_id"`
+	AuthorID       int       `json:"author_id"`
+	AuthorUsername string    `json:"author_username"`
+	Body           string    `json:"body"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+func ValidateEntryComment(v *validator.Validator, comment *EntryComment) {
+	v.Check(comment.Body != "", "body", "must be provided")
+	v.Check(len(comment.Body) <= 2000, "body", "must not be more than 2000 bytes long")
+}
+
+// AddEntryComment comments on an entry of the workout as userID.
+// ErrWorkoutForbidden unless the user may read the workout, ErrNotFound
+// when the entry isn't part of it
+func (pg *PostgresWorkoutStore) AddEntryComment(ctx context.Context, workoutID int64, comment *EntryComment, userID int) error {
+	ctx, span := startSpan(ctx, "WorkoutStore.AddEntryComment")
+	defer span.End()
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	err := checkWorkoutReader(ctx, pg.db, workoutID, userID)
+	if err != nil {
+		return err
+	}
+
+	// the access check is repeated in the insert, a revoked coach can't get
+	// a comment in after the check above
+	query := `
+  INSERT INTO entry_comments (workout_entry_id, author_id, body)
+  SELECT e.id, $3, $4
+  FROM workout_entries e
+  INNER JOIN workouts w ON w.id = e.workout_id
+  WHERE e.id = $1 AND w.id = $2 AND ` + workoutReadableBy("w.user_id", "$3") + `
+  RETURNING id, created_at, (SELECT username FROM users WHERE id = $3)
+  `
+
+	comment.AuthorID = userID
+	err = pg.db.QueryRowContext(ctx, query, comment.WorkoutEntryID, workoutID, userID, comment.Body).Scan(&comment.ID, &comment.CreatedAt, &comment.AuthorUsername)
+	return translateError(err)
+}
+
+// ListWorkoutComments the comments on every entry of the workout, oldest
+// first, for the owner and their coaches
+func (pg *PostgresWorkoutStore) ListWorkoutComments(ctx context.Context, workoutID int64, userID int) ([]*EntryComment, error) {
+	ctx, span := startSpan(ctx, "WorkoutStore.ListWorkoutComments")
+	defer span.End()
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	err := checkWorkoutReader(ctx, pg.db, workoutID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `
+  SELECT c.id, c.workout_entry_id, c.author_id, u.username, c.body, c.created_at
+  FROM entry_comments c
+  INNER JOIN workout_entries e ON e.id = c.workout_entry_id
+  INNER JOIN workouts w ON w.id = e.workout_id
+  INNER JOIN users u ON u.id = c.author_id
+  WHERE w.id = $1 AND ` + workoutReadableBy("w.user_id", "$2") + `
+  ORDER BY c.created_at, c.id
+  `
+
+	rows, err := pg.db.QueryContext(ctx, query, workoutID, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	comments := []*EntryComment{}
+	for rows.Next() {
+		comment := &EntryComment{}
+		err = rows.Scan(&comment.ID, &comment.WorkoutEntryID, &comment.AuthorID, &comment.AuthorUsername, &comment.Body, &comment.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, comment)
+	}
+
+	return comments, rows.Err()
+}
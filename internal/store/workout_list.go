@@ -0,0 +1,280 @@
+package store
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultWorkoutListLimit = 20
+	MaxWorkoutListLimit     = 100
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+var ErrInvalidSort = errors.New("invalid sort option")
+
+// WorkoutFilter narrows down the list of workouts for a single user.
+// nil pointers and empty strings mean "don't filter on this"
+type WorkoutFilter struct {
+	UserID int
+	// ViewerID the user asking when it isn't the owner, only coaches of
+	// the owner get any rows
+	ViewerID    int
+	From        *time.Time // inclusive, on created_at
+	To          *time.Time // exclusive, on created_at
+	Title       string     // case insensitive substring
+	MinDuration *int
+	MaxDuration *int
+	MinCalories *int
+	MaxCalories *int
+	Sort        string // column name, prefix with "-" for descending
+	Limit       int
+	Cursor      string
+}
+
+// sortable columns, calories can be NULL so we coalesce it for a stable keyset
+var workoutSortColumns = map[string]string{
+	"created_at":       "w.created_at",
+	"duration_minutes": "w.duration_minutes",
+	"calories_burned":  "COALESCE(w.calories_burned, 0)",
+}
+
+// workoutCursor is the position of the last row of a page, it gets
+// base64 encoded so clients treat it as an opaque string
+type workoutCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int    `json:"id"`
+}
+
+func encodeWorkoutCursor(sort string, workout *Workout) string {
+	c := workoutCursor{Sort: sort, ID: workout.ID}
+	switch strings.TrimPrefix(sort, "-") {
+	case "duration_minutes":
+		c.Value = strconv.Itoa(workout.DurationMinutes)
+	case "calories_burned":
+		c.Value = strconv.Itoa(workout.CaloriesBurned)
+	default:
+		c.Value = workout.CreatedAt.Format(time.RFC3339Nano)
+	}
+
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// decodeWorkoutCursor returns the typed sort value and id of the cursor.
+// a cursor is only valid for the sort it was issued with
+func decodeWorkoutCursor(sort, cursor string) (any, int, error) {
+	js, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, 0, ErrInvalidCursor
+	}
+
+	var c workoutCursor
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.Sort != sort {
+		return nil, 0, ErrInvalidCursor
+	}
+
+	switch strings.TrimPrefix(sort, "-") {
+	case "duration_minutes", "calories_burned":
+		v, err := strconv.Atoi(c.Value)
+		if err != nil {
+			return nil, 0, ErrInvalidCursor
+		}
+		return v, c.ID, nil
+	default:
+		v, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, 0, ErrInvalidCursor
+		}
+		return v, c.ID, nil
+	}
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// ListWorkouts returns a page of the user's workouts and the cursor for the
+// next page, the cursor is empty when there are no more results
+func (pg *PostgresWorkoutStore) ListWorkouts(ctx context.Context, filter WorkoutFilter) ([]*Workout, string, error) {
+	ctx, span := startSpan(ctx, "WorkoutStore.ListWorkouts")
+	defer span.End()
+	ctx, cancel := pg.withTimeout(ctx)
+	defer cancel()
+
+	if filter.Sort == "" {
+		filter.Sort = "-created_at"
+	}
+	desc := strings.HasPrefix(filter.Sort, "-")
+	column, ok := workoutSortColumns[strings.TrimPrefix(filter.Sort, "-")]
+	if !ok {
+		return nil, "", ErrInvalidSort
+	}
+
+	if filter.Limit <= 0 {
+		filter.Limit = DefaultWorkoutListLimit
+	}
+	if filter.Limit > MaxWorkoutListLimit {
+		filter.Limit = MaxWorkoutListLimit
+	}
+
+	conditions := []string{"w.user_id = $1"}
+	args := []any{filter.UserID}
+	addCondition := func(format string, values ...any) {
+		placeholders := make([]any, len(values))
+		for i, v := range values {
+			args = append(args, v)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		conditions = append(conditions, fmt.Sprintf(format, placeholders...))
+	}
+
+	if filter.ViewerID != 0 && filter.ViewerID != filter.UserID {
+		addCondition(workoutReadableBy("w.user_id", "%[1]s"), filter.ViewerID)
+	}
+	if filter.From != nil {
+		addCondition("w.created_at >= %s", *filter.From)
+	}
+	if filter.To != nil {
+		addCondition("w.created_at < %s", *filter.To)
+	}
+	if filter.Title != "" {
+		addCondition("w.title ILIKE '%%' || %s || '%%'", escapeLike(filter.Title))
+	}
+	if filter.MinDuration != nil {
+		addCondition("w.duration_minutes >= %s", *filter.MinDuration)
+	}
+	if filter.MaxDuration != nil {
+		addCondition("w.duration_minutes <= %s", *filter.MaxDuration)
+	}
+	if filter.MinCalories != nil {
+		addCondition("w.calories_burned >= %s", *filter.MinCalories)
+	}
+	if filter.MaxCalories != nil {
+		addCondition("w.calories_burned <= %s", *filter.MaxCalories)
+	}
+
+	direction, comparison := "ASC", ">"
+	if desc {
+		direction, comparison = "DESC", "<"
+	}
+
+	if filter.Cursor != "" {
+		value, id, err := decodeWorkoutCursor(filter.Sort, filter.Cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		addCondition("("+column+", w.id) "+comparison+" (%s, %s)", value, id)
+	}
+
+	// fetch one extra row so we know if there is a next page
+	args = append(args, filter.Limit+1)
+	query := fmt.Sprintf(`
+SELECT w.id, w.user_id, w.title, COALESCE(w.description, ''), w.duration_minutes, COALESCE(w.calories_burned, 0), w.created_at
+FROM workouts w
+WHERE %s
+ORDER BY %s %s, w.id %s
+LIMIT $%d
+`, strings.Join(conditions, " AND "), column, direction, direction, len(args))
+
+	rows, err := pg.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	workouts := []*Workout{}
+	for rows.Next() {
+		workout := &Workout{Entries: []WorkoutEntry{}}
+		err = rows.Scan(
+			&workout.ID,
+			&workout.UserID,
+			&workout.Title,
+			&workout.Description,
+			&workout.DurationMinutes,
+			&workout.CaloriesBurned,
+			&workout.CreatedAt,
+		)
+		if err != nil {
+			return nil, "", err
+		}
+		workouts = append(workouts, workout)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, "", err
+	}
+
+	nextCursor := ""
+	if len(workouts) > filter.Limit {
+		workouts = workouts[:filter.Limit]
+		nextCursor = encodeWorkoutCursor(filter.Sort, workouts[len(workouts)-1])
+	}
+
+	err = pg.loadEntries(ctx, workouts)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return workouts, nextCursor, nil
+}
+
+// loadEntries fills in the entries for a page of workouts in a single query
+func (pg *PostgresWorkoutStore) loadEntries(ctx context.Context, workouts []*Workout) error {
+	if len(workouts) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(workouts))
+	byID := make(map[int]*Workout, len(workouts))
+	for i, workout := range workouts {
+		ids[i] = int64(workout.ID)
+		byID[workout.ID] = workout
+	}
+
+	query := `
+SELECT workout_id, id, exercise_id, exercise_name, sets, reps, duration_seconds, weight, COALESCE(notes, ''), order_index
+FROM workout_entries
+WHERE workout_id = ANY($1)
+ORDER BY workout_id, order_index
+`
+	rows, err := pg.db.QueryContext(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var workoutID int
+		var entry WorkoutEntry
+		err = rows.Scan(
+			&workoutID,
+			&entry.ID,
+			&entry.ExerciseID,
+			&entry.ExerciseName,
+			&entry.setCount,
+			&entry.Reps,
+			&entry.DurationSeconds,
+			&entry.Weight,
+			&entry.Notes,
+			&entry.OrderIndex,
+		)
+		if err != nil {
+			return err
+		}
+		workout := byID[workoutID]
+		workout.Entries = append(workout.Entries, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	return attachSets(ctx, pg.db, workoutSetsTable, workoutEntryPointers(workouts...))
+}
@@ -0,0 +1,295 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/nickemma/internal/validator"
+)
+
+const (
+	SetTypeWarmup  = "warmup"
+	SetTypeWorking = "working"
+	SetTypeDrop    = "drop"
+	SetTypeFailure = "failure"
+)
+
+// WorkoutSet a single logged set of an entry, like 10 reps at 70kg
+type WorkoutSet struct {
+	ID              int      `json:"id"`
+	SetNumber       int      `json:"set_number"`
+	SetType         string   `json:"set_type"`
+	Reps            *int     `json:"reps"`
+	DurationSeconds *int     `json:"duration_seconds"`
+	Weight          *float64 `json:"weight"`
+	RPE             *float64 `json:"rpe"`
+}
+
+// the per-set tables, workouts and templates share the same layout
+const (
+	workoutSetsTable  = "workout_sets"
+	templateSetsTable = "template_sets"
+)
+
+// MaxLegacySets the largest aggregate "sets" count a request may expand
+const MaxLegacySets = 100
+
+// ExpandSets turns the old aggregate "3 sets of 10 at 60kg" into 3 working sets
+func ExpandSets(count int, reps, durationSeconds *int, weight *float64) []WorkoutSet {
+	sets := make([]WorkoutSet, count)
+	for i := range sets {
+		sets[i] = WorkoutSet{
+			SetNumber:       i + 1,
+			SetType:         SetTypeWorking,
+			Reps:            reps,
+			DurationSeconds: durationSeconds,
+			Weight:          weight,
+		}
+	}
+	return sets
+}
+
+// UnmarshalJSON accepts "sets" either as the list of sets or as the old
+// aggregate count, in which case reps/weight/duration_seconds apply to every set
+func (e *WorkoutEntry) UnmarshalJSON(data []byte) error {
+	type alias WorkoutEntry
+	aux := struct {
+		*alias
+		Sets json.RawMessage `json:"sets"`
+	}{alias: (*alias)(e)}
+
+	err := json.Unmarshal(data, &aux)
+	if err != nil {
+		return err
+	}
+
+	raw := bytes.TrimSpace(aux.Sets)
+	switch {
+	case len(raw) == 0 || bytes.Equal(raw, []byte("null")):
+		e.Sets = nil
+	case raw[0] == '[':
+		return json.Unmarshal(raw, &e.Sets)
+	default:
+		var count int
+		err = json.Unmarshal(raw, &count)
+		if err != nil {
+			return fmt.Errorf("sets must be a list of sets or a number: %w", err)
+		}
+		if count < 0 || count > MaxLegacySets {
+			return fmt.Errorf("sets must be between 0 and %d", MaxLegacySets)
+		}
+		e.Sets = ExpandSets(count, e.Reps, e.DurationSeconds, e.Weight)
+	}
+
+	return nil
+}
+
+// ValidateEntries checks what valid_workout_entry and the set constraints
+// would reject, plus the limits of the numeric columns. workouts and
+// templates share it
+func ValidateEntries(v *validator.Validator, entries []WorkoutEntry) {
+	for i := range entries {
+		entry := &entries[i]
+		key := fmt.Sprintf("entries[%d]", i)
+
+		v.Check(entry.ExerciseName != "" || entry.ExerciseID != nil, key+".exercise_name", "must be provided")
+		v.Check(len(entry.ExerciseName) <= 255, key+".exercise_name", "must not be more than 255 bytes long")
+		v.Check(entry.OrderIndex >= 0, key+".order_index", "must not be negative")
+
+		if len(entry.Sets) == 0 {
+			validateSetValues(v, key, entry.Reps, entry.DurationSeconds, entry.Weight)
+			continue
+		}
+
+		for j := range entry.Sets {
+			set := &entry.Sets[j]
+			setKey := fmt.Sprintf("%s.sets[%d]", key, j)
+			validateSetValues(v, setKey, set.Reps, set.DurationSeconds, set.Weight)
+			if set.SetType != "" {
+				v.Check(validator.PermittedValue(set.SetType, SetTypeWarmup, SetTypeWorking, SetTypeDrop, SetTypeFailure), setKey+".set_type", "must be warmup, working, drop or failure")
+			}
+			if set.RPE != nil {
+				v.Check(*set.RPE >= 1 && *set.RPE <= 10, setKey+".rpe", "must be between 1 and 10")
+			}
+		}
+	}
+}
+
+// validateSetValues weight is DECIMAL(5, 2), so at most 999.99
+func validateSetValues(v *validator.Validator, key string, reps, durationSeconds *int, weight *float64) {
+	switch {
+	case reps == nil && durationSeconds == nil:
+		v.AddError(key+".reps", "either reps or duration_seconds must be provided")
+	case reps != nil && durationSeconds != nil:
+		v.AddError(key+".reps", "must not be provided together with duration_seconds")
+	}
+	if reps != nil {
+		v.Check(*reps > 0, key+".reps", "must be greater than zero")
+	}
+	if durationSeconds != nil {
+		v.Check(*durationSeconds > 0, key+".duration_seconds", "must be greater than zero")
+	}
+	if weight != nil {
+		v.Check(*weight >= 0, key+".weight", "must not be negative")
+		v.Check(*weight < 1000, key+".weight", "must be less than 1000")
+	}
+}
+
+// summarizeSets numbers the sets and fills the aggregate columns of the
+// entry from its top set, so the entry row still describes the exercise on
+// its own and satisfies valid_workout_entry
+func (e *WorkoutEntry) summarizeSets() {
+	if len(e.Sets) == 0 {
+		return
+	}
+
+	top := -1
+	for i := range e.Sets {
+		set := &e.Sets[i]
+		set.SetNumber = i + 1
+		if set.SetType == "" {
+			set.SetType = SetTypeWorking
+		}
+		if top == -1 || isHeavierSet(set, &e.Sets[top]) {
+			top = i
+		}
+	}
+
+	e.setCount = len(e.Sets)
+	e.Reps = e.Sets[top].Reps
+	e.DurationSeconds = e.Sets[top].DurationSeconds
+	e.Weight = e.Sets[top].Weight
+}
+
+// isHeavierSet warmups never win over other sets, then weight, reps and duration
+func isHeavierSet(a, b *WorkoutSet) bool {
+	if (a.SetType == SetTypeWarmup) != (b.SetType == SetTypeWarmup) {
+		return b.SetType == SetTypeWarmup
+	}
+	if w1, w2 := floatOrZero(a.Weight), floatOrZero(b.Weight); w1 != w2 {
+		return w1 > w2
+	}
+	if r1, r2 := intOrZero(a.Reps), intOrZero(b.Reps); r1 != r2 {
+		return r1 > r2
+	}
+	return intOrZero(a.DurationSeconds) > intOrZero(b.DurationSeconds)
+}
+
+func intOrZero(i *int) int {
+	if i == nil {
+		return 0
+	}
+	return *i
+}
+
+func floatOrZero(f *float64) float64 {
+	if f == nil {
+		return 0
+	}
+	return *f
+}
+
+// insertWorkoutEntry writes the entry row and its sets
+func insertWorkoutEntry(ctx context.Context, tx *sql.Tx, workoutID, userID int, entry *WorkoutEntry) error {
+	entry.summarizeSets()
+	err := resolveExercise(ctx, tx, userID, entry)
+	if err != nil {
+		return err
+	}
+
+	query := `
+INSERT INTO workout_entries (workout_id, exercise_id, exercise_name, sets, reps, duration_seconds, weight, notes, order_index)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+RETURNING id
+`
+	err = tx.QueryRowContext(ctx, query, workoutID, entry.ExerciseID, entry.ExerciseName, entry.setCount, entry.Reps, entry.DurationSeconds, entry.Weight, entry.Notes, entry.OrderIndex).Scan(&entry.ID)
+	if err != nil {
+		return err
+	}
+
+	return insertSets(ctx, tx, workoutSetsTable, entry)
+}
+
+func insertSets(ctx context.Context, tx *sql.Tx, table string, entry *WorkoutEntry) error {
+	query := fmt.Sprintf(`
+INSERT INTO %s (entry_id, set_number, set_type, reps, duration_seconds, weight, rpe)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id
+`, table)
+
+	for i := range entry.Sets {
+		set := &entry.Sets[i]
+		err := tx.QueryRowContext(ctx, query, entry.ID, set.SetNumber, set.SetType, set.Reps, set.DurationSeconds, set.Weight, set.RPE).Scan(&set.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachSets loads the sets of the given entries in one query. entries logged
+// before per-set tracking have no set rows, their sets are expanded from the
+// aggregate columns instead
+func attachSets(ctx context.Context, db querier, table string, entries []*WorkoutEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, len(entries))
+	byID := make(map[int]*WorkoutEntry, len(entries))
+	for i, entry := range entries {
+		ids[i] = int64(entry.ID)
+		entry.Sets = nil
+		byID[entry.ID] = entry
+	}
+
+	query := fmt.Sprintf(`
+SELECT entry_id, id, set_number, set_type, reps, duration_seconds, weight, rpe
+FROM %s
+WHERE entry_id = ANY($1)
+ORDER BY entry_id, set_number
+`, table)
+
+	rows, err := db.QueryContext(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var entryID int
+		var set WorkoutSet
+		err = rows.Scan(&entryID, &set.ID, &set.SetNumber, &set.SetType, &set.Reps, &set.DurationSeconds, &set.Weight, &set.RPE)
+		if err != nil {
+			return err
+		}
+		entry := byID[entryID]
+		entry.Sets = append(entry.Sets, set)
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.Sets == nil {
+			entry.Sets = ExpandSets(entry.setCount, entry.Reps, entry.DurationSeconds, entry.Weight)
+		}
+	}
+
+	return nil
+}
+
+// workoutEntryPointers collects the entries of the workouts so attachSets
+// can fill them in place
+func workoutEntryPointers(workouts ...*Workout) []*WorkoutEntry {
+	var entries []*WorkoutEntry
+	for _, workout := range workouts {
+		for i := range workout.Entries {
+			entries = append(entries, &workout.Entries[i])
+		}
+	}
+	return entries
+}
@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/nickemma/internal/validator"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkoutEntryUnmarshalSets(t *testing.T) {
+	var legacy WorkoutEntry
+	err := json.Unmarshal([]byte(`{"exercise_name": "Squats", "sets": 3, "reps": 12, "weight": 100.5}`), &legacy)
+	require.NoError(t, err)
+	require.Len(t, legacy.Sets, 3)
+	assert.Equal(t, 3, legacy.Sets[2].SetNumber)
+	assert.Equal(t, 12, *legacy.Sets[2].Reps)
+	assert.Equal(t, 100.5, *legacy.Sets[2].Weight)
+
+	var perSet WorkoutEntry
+	err = json.Unmarshal([]byte(`{"exercise_name": "Bench", "sets": [{"reps": 12, "weight": 60, "set_type": "warmup"}, {"reps": 8, "weight": 80, "rpe": 9}]}`), &perSet)
+	require.NoError(t, err)
+	require.Len(t, perSet.Sets, 2)
+	assert.Equal(t, SetTypeWarmup, perSet.Sets[0].SetType)
+	assert.Equal(t, 9.0, *perSet.Sets[1].RPE)
+
+	var invalid WorkoutEntry
+	err = json.Unmarshal([]byte(`{"exercise_name": "Bench", "sets": "three"}`), &invalid)
+	assert.Error(t, err)
+	err = json.Unmarshal([]byte(`{"exercise_name": "Bench", "sets": -1, "reps": 5}`), &invalid)
+	assert.Error(t, err)
+	err = json.Unmarshal([]byte(`{"exercise_name": "Bench", "sets": 1000000000, "reps": 5}`), &invalid)
+	assert.Error(t, err)
+}
+
+func TestSummarizeSets(t *testing.T) {
+	entry := WorkoutEntry{
+		Sets: []WorkoutSet{
+			{SetType: SetTypeWarmup, Reps: IntPointer(5), Weight: FloatPointer(100)},
+			{Reps: IntPointer(12), Weight: FloatPointer(60)},
+			{Reps: IntPointer(8), Weight: FloatPointer(80)},
+			{SetType: SetTypeDrop, Reps: IntPointer(10), Weight: FloatPointer(80)},
+		},
+	}
+	entry.summarizeSets()
+
+	assert.Equal(t, 4, entry.setCount)
+	assert.Equal(t, 10, *entry.Reps)
+	assert.Equal(t, 80.0, *entry.Weight)
+	assert.Equal(t, SetTypeWorking, entry.Sets[1].SetType)
+	assert.Equal(t, 4, entry.Sets[3].SetNumber)
+}
+
+func TestValidateWorkout(t *testing.T) {
+	v := validator.New()
+	ValidateWorkout(v, &Workout{
+		DurationMinutes: -5,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "Plank", DurationSeconds: IntPointer(60)},
+			{ExerciseName: "Squats", Reps: IntPointer(10), DurationSeconds: IntPointer(30)},
+			{Sets: []WorkoutSet{
+				{Reps: IntPointer(5), Weight: FloatPointer(1200)},
+				{SetType: "heavy"},
+			}},
+		},
+	})
+
+	assert.Equal(t, map[string]string{
+		"title":                       "must be provided",
+		"duration_minutes":            "must be greater than zero",
+		"entries[1].reps":             "must not be provided together with duration_seconds",
+		"entries[2].exercise_name":    "must be provided",
+		"entries[2].sets[0].weight":   "must be less than 1000",
+		"entries[2].sets[1].reps":     "either reps or duration_seconds must be provided",
+		"entries[2].sets[1].set_type": "must be warmup, working, drop or failure",
+	}, v.Errors)
+}
+
+func TestPerSetLogging(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresWorkoutStore(db)
+	user := createTestUser(t, db, "pyramid")
+
+	workout, err := store.CreateWorkout(ctx, &Workout{
+		UserID:          user.ID,
+		Title:           "pyramid",
+		DurationMinutes: 30,
+		Entries: []WorkoutEntry{
+			{
+				ExerciseName: "Bench press",
+				Sets: []WorkoutSet{
+					{Reps: IntPointer(12), Weight: FloatPointer(60)},
+					{Reps: IntPointer(10), Weight: FloatPointer(70), RPE: FloatPointer(8)},
+					{Reps: IntPointer(8), Weight: FloatPointer(80), SetType: SetTypeFailure},
+				},
+				OrderIndex: 1,
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	// an entry logged before per-set tracking, without any workout_sets rows
+	_, err = db.Exec(`
+INSERT INTO workout_entries (workout_id, exercise_name, sets, reps, weight, order_index)
+VALUES ($1, 'Row', 2, 10, 50, 2)`, workout.ID)
+	require.NoError(t, err)
+
+	retrieved, err := store.GetWorkoutByID(ctx, int64(workout.ID), user.ID)
+	require.NoError(t, err)
+	require.Len(t, retrieved.Entries, 2)
+
+	bench := retrieved.Entries[0]
+	require.Len(t, bench.Sets, 3)
+	assert.Equal(t, 70.0, *bench.Sets[1].Weight)
+	assert.Equal(t, 8.0, *bench.Sets[1].RPE)
+	assert.Equal(t, SetTypeFailure, bench.Sets[2].SetType)
+	assert.Equal(t, 80.0, *bench.Weight)
+
+	row := retrieved.Entries[1]
+	require.Len(t, row.Sets, 2)
+	assert.Equal(t, 10, *row.Sets[1].Reps)
+	assert.Equal(t, SetTypeWorking, row.Sets[1].SetType)
+}
@@ -1,11 +1,14 @@
 package store
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
 func SetupTestDB(t *testing.T) *sql.DB {
@@ -21,17 +24,34 @@ func SetupTestDB(t *testing.T) *sql.DB {
 		t.Fatalf("migrating test db error: %v", err)
 	}
 
-	_, err = db.Exec(`TRUNCATE workouts, workout_entries CASCADE`)
+	_, err = db.Exec(`TRUNCATE workouts, workout_entries, rate_limits, audit_log CASCADE`)
 	if err != nil {
 		t.Fatalf("truncating table error: %v", err)
 	}
+
+	// users are deleted instead of truncated so the cascade keeps the shared
+	// exercise catalog seeded by the migrations
+	_, err = db.Exec(`DELETE FROM users`)
+	if err != nil {
+		t.Fatalf("deleting users error: %v", err)
+	}
 	return db
 }
+
+func createTestUser(t *testing.T, db *sql.DB, username string) *User {
+	user := &User{Username: username, Email: username + "@example.com"}
+	require.NoError(t, user.PasswordHash.Set("password123"))
+	require.NoError(t, NewPostgresUserStore(db).CreateUser(context.Background(), user))
+	return user
+}
+
 func TestCreate(t *testing.T) {
+	ctx := context.Background()
 	db := SetupTestDB(t)
 	defer db.Close()
 
 	store := NewPostgresWorkoutStore(db)
+	user := createTestUser(t, db, "tester")
 	// table driven test
 	test := []struct {
 		name    string
@@ -45,9 +65,7 @@ func TestCreate(t *testing.T) {
 		Entries: []WorkoutEntry{
 			{
 				ExerciseName: "Bench press",
-				Sets:         3,
-				Reps:         IntPointer(10),
-				Weight:       FloatPointer(135.5),
+				Sets:         ExpandSets(3, IntPointer(10), nil, FloatPointer(135.5)),
 				Notes:        "Awesome today",
 				OrderIndex:   1,
 			},
@@ -65,19 +83,15 @@ func TestCreate(t *testing.T) {
 				Entries: []WorkoutEntry{
 					{
 						ExerciseName: "Plank",
-						Sets:         3,
-						Reps:         IntPointer(60),
+						Sets:         ExpandSets(3, IntPointer(60), nil, nil),
 						Notes:        "keep form",
 						OrderIndex:   1,
 					},
 					{
-						ExerciseName:    "Plank",
-						Sets:            3,
-						Reps:            IntPointer(12),
-						DurationSeconds: IntPointer(60),
-						Weight:          FloatPointer(185.6),
-						Notes:           "full depth",
-						OrderIndex:      2,
+						ExerciseName: "Plank",
+						Sets:         ExpandSets(3, IntPointer(12), IntPointer(60), FloatPointer(185.6)),
+						Notes:        "full depth",
+						OrderIndex:   2,
 					},
 				},
 			},
@@ -87,7 +101,8 @@ func TestCreate(t *testing.T) {
 
 	for _, tt := range test {
 		t.Run(tt.name, func(t *testing.T) {
-			createdWorkout, err := store.CreateWorkout(tt.workout)
+			tt.workout.UserID = user.ID
+			createdWorkout, err := store.CreateWorkout(ctx, tt.workout)
 			if tt.wantErr {
 				assert.Error(t, err)
 				return
@@ -98,7 +113,7 @@ func TestCreate(t *testing.T) {
 			assert.Equal(t, tt.workout.DurationMinutes, createdWorkout.DurationMinutes)
 			assert.Equal(t, tt.workout.CaloriesBurned, createdWorkout.CaloriesBurned)
 
-			retrieved, err := store.GetWorkoutByID(int64(createdWorkout.ID))
+			retrieved, err := store.GetWorkoutByID(ctx, int64(createdWorkout.ID), user.ID)
 			require.NoError(t, err)
 
 			assert.Equal(t, createdWorkout.ID, retrieved.ID)
@@ -114,6 +129,113 @@ func TestCreate(t *testing.T) {
 	}
 }
 
+func TestWorkoutOwnership(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresWorkoutStore(db)
+	owner := createTestUser(t, db, "owner")
+	other := createTestUser(t, db, "other")
+
+	workout, err := store.CreateWorkout(ctx, &Workout{
+		UserID:          owner.ID,
+		Title:           "leg day",
+		DurationMinutes: 45,
+		Entries: []WorkoutEntry{
+			{ExerciseName: "Squat", Sets: ExpandSets(3, IntPointer(5), nil, nil), OrderIndex: 1},
+		},
+	})
+	require.NoError(t, err)
+
+	_, err = store.GetWorkoutByID(ctx, int64(workout.ID), other.ID)
+	assert.ErrorIs(t, err, ErrWorkoutForbidden)
+
+	err = store.UpdateWorkout(ctx, workout, other.ID)
+	assert.ErrorIs(t, err, ErrWorkoutForbidden)
+
+	err = store.DeleteWorkout(ctx, int64(workout.ID), other.ID)
+	assert.ErrorIs(t, err, ErrWorkoutForbidden)
+
+	err = store.DeleteWorkout(ctx, int64(workout.ID)+1000, owner.ID)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	require.NoError(t, store.DeleteWorkout(ctx, int64(workout.ID), owner.ID))
+}
+
+func TestListWorkouts(t *testing.T) {
+	ctx := context.Background()
+	db := SetupTestDB(t)
+	defer db.Close()
+
+	store := NewPostgresWorkoutStore(db)
+	user := createTestUser(t, db, "lister")
+	other := createTestUser(t, db, "someone_else")
+
+	for i := 1; i <= 5; i++ {
+		_, err := store.CreateWorkout(ctx, &Workout{
+			UserID:          user.ID,
+			Title:           fmt.Sprintf("run %d", i),
+			DurationMinutes: i * 10,
+			CaloriesBurned:  i * 100,
+			Entries: []WorkoutEntry{
+				{ExerciseName: "Run", Sets: ExpandSets(1, nil, IntPointer(i*600), nil), OrderIndex: 1},
+			},
+		})
+		require.NoError(t, err)
+	}
+	_, err := store.CreateWorkout(ctx, &Workout{UserID: other.ID, Title: "run 99", DurationMinutes: 99})
+	require.NoError(t, err)
+
+	// walk every page sorted by duration
+	var titles []string
+	cursor := ""
+	for {
+		page, next, err := store.ListWorkouts(ctx, WorkoutFilter{UserID: user.ID, Sort: "duration_minutes", Limit: 2, Cursor: cursor})
+		require.NoError(t, err)
+		for _, workout := range page {
+			titles = append(titles, workout.Title)
+			assert.Len(t, workout.Entries, 1)
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	assert.Equal(t, []string{"run 1", "run 2", "run 3", "run 4", "run 5"}, titles)
+
+	page, next, err := store.ListWorkouts(ctx, WorkoutFilter{
+		UserID:      user.ID,
+		Title:       "RUN",
+		MinDuration: IntPointer(20),
+		MaxCalories: IntPointer(400),
+		Sort:        "-calories_burned",
+	})
+	require.NoError(t, err)
+	assert.Empty(t, next)
+	require.Len(t, page, 3)
+	assert.Equal(t, "run 4", page[0].Title)
+
+	_, _, err = store.ListWorkouts(ctx, WorkoutFilter{UserID: user.ID, Sort: "-created_at", Cursor: cursor})
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
+func TestWorkoutCursor(t *testing.T) {
+	workout := &Workout{ID: 7, DurationMinutes: 45, CreatedAt: time.Date(2025, 3, 1, 8, 30, 0, 123000, time.UTC)}
+
+	value, id, err := decodeWorkoutCursor("-created_at", encodeWorkoutCursor("-created_at", workout))
+	require.NoError(t, err)
+	assert.Equal(t, 7, id)
+	assert.True(t, workout.CreatedAt.Equal(value.(time.Time)))
+
+	value, _, err = decodeWorkoutCursor("duration_minutes", encodeWorkoutCursor("duration_minutes", workout))
+	require.NoError(t, err)
+	assert.Equal(t, 45, value)
+
+	_, _, err = decodeWorkoutCursor("duration_minutes", "not-a-cursor")
+	assert.ErrorIs(t, err, ErrInvalidCursor)
+}
+
 func IntPointer(i int) *int {
 	return &i
 }
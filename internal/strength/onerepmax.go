@@ -0,0 +1,67 @@
+package strength
+
+import (
+	"fmt"
+	"math"
+)
+
+// Formula estimates a one rep max from a set of reps at a weight
+type Formula string
+
+const (
+	FormulaEpley    Formula = "epley"
+	FormulaBrzycki  Formula = "brzycki"
+	FormulaLombardi Formula = "lombardi"
+)
+
+// ParseFormula an empty name falls back to Epley
+func ParseFormula(name string) (Formula, error) {
+	switch Formula(name) {
+	case "":
+		return FormulaEpley, nil
+	case FormulaEpley, FormulaBrzycki, FormulaLombardi:
+		return Formula(name), nil
+	}
+	return "", fmt.Errorf("unknown one rep max formula %q, use epley, brzycki or lombardi", name)
+}
+
+// Estimate the one rep max with the formula, 0 when there is nothing to estimate
+func (f Formula) Estimate(weight float64, reps int) float64 {
+	switch f {
+	case FormulaBrzycki:
+		return Brzycki(weight, reps)
+	case FormulaLombardi:
+		return Lombardi(weight, reps)
+	default:
+		return Epley(weight, reps)
+	}
+}
+
+// Epley estimated one rep max, weight * (1 + reps / 30). a single rep is
+// the one rep max itself
+func Epley(weight float64, reps int) float64 {
+	if reps <= 0 || weight <= 0 {
+		return 0
+	}
+	if reps == 1 {
+		return weight
+	}
+	return weight * (1 + float64(reps)/30)
+}
+
+// Brzycki estimated one rep max, weight * 36 / (37 - reps). the formula
+// breaks down from 37 reps so those sets are not estimated
+func Brzycki(weight float64, reps int) float64 {
+	if reps <= 0 || reps >= 37 || weight <= 0 {
+		return 0
+	}
+	return weight * 36 / float64(37-reps)
+}
+
+// Lombardi estimated one rep max, weight * reps ^ 0.10
+func Lombardi(weight float64, reps int) float64 {
+	if reps <= 0 || weight <= 0 {
+		return 0
+	}
+	return weight * math.Pow(float64(reps), 0.10)
+}
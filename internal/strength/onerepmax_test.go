@@ -0,0 +1,45 @@
+package strength
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		name    string
+		formula Formula
+		weight  float64
+		reps    int
+		want    float64
+	}{
+		{name: "epley", formula: FormulaEpley, weight: 100, reps: 5, want: 116.67},
+		{name: "epley single", formula: FormulaEpley, weight: 100, reps: 1, want: 100},
+		{name: "brzycki", formula: FormulaBrzycki, weight: 100, reps: 5, want: 112.5},
+		{name: "brzycki too many reps", formula: FormulaBrzycki, weight: 100, reps: 37, want: 0},
+		{name: "lombardi", formula: FormulaLombardi, weight: 100, reps: 5, want: 117.46},
+		{name: "no reps", formula: FormulaEpley, weight: 100, reps: 0, want: 0},
+		{name: "no weight", formula: FormulaLombardi, weight: 0, reps: 10, want: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.InDelta(t, tt.want, tt.formula.Estimate(tt.weight, tt.reps), 0.01)
+		})
+	}
+}
+
+func TestParseFormula(t *testing.T) {
+	formula, err := ParseFormula("")
+	require.NoError(t, err)
+	assert.Equal(t, FormulaEpley, formula)
+
+	formula, err = ParseFormula("brzycki")
+	require.NoError(t, err)
+	assert.Equal(t, FormulaBrzycki, formula)
+
+	_, err = ParseFormula("wathan")
+	assert.Error(t, err)
+}
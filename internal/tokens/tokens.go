@@ -4,11 +4,30 @@ import (
 	"crypto/rand"
 	"crypto/sha256"
 	"encoding/base32"
+	"encoding/hex"
 	"time"
 )
 
 const (
-	ScopeAuth = "authentication"
+	ScopeAuth          = "authentication"
+	ScopeRefresh       = "refresh"
+	ScopePasswordReset = "password-reset"
+	ScopeActivation    = "activation"
+	// ScopeTwoFactorPending the password was right, a TOTP or recovery code
+	// still has to be given at POST /tokens/2fa
+	ScopeTwoFactorPending = "2fa-pending"
+	// ScopeCoachInvite belongs to the invited athlete, the coach is on the
+	// coach_athletes row that holds its hash
+	ScopeCoachInvite = "coach-invite"
+)
+
+const (
+	AccessTokenTTL           = time.Hour
+	RefreshTokenTTL          = 30 * 24 * time.Hour
+	PasswordResetTokenTTL    = 45 * time.Minute
+	ActivationTokenTTL       = 3 * 24 * time.Hour
+	TwoFactorPendingTokenTTL = 5 * time.Minute
+	CoachInviteTokenTTL      = 7 * 24 * time.Hour
 )
 
 type Token struct {
@@ -17,6 +36,10 @@ type Token struct {
 	UserID    int       `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	UserAgent string    `json:"-"`
+	// Family ties an access token to the refresh token chain it came from,
+	// so the whole chain can be revoked at once
+	Family string `json:"-"`
 }
 
 func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error) {
@@ -33,7 +56,22 @@ func GenerateToken(userID int, ttl time.Duration, scope string) (*Token, error)
 	}
 
 	token.Plaintext = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(emptyBytes)
-	hash := sha256.Sum256([]byte(token.Plaintext))
-	token.Hash = hash[:]
+	token.Hash = Hash(token.Plaintext)
 	return token, nil
 }
+
+// Hash only the hash of a token is stored, this is how plaintext tokens are looked up
+func Hash(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
+// NewFamily a random id for a new login, shared by every token rotated from it
+func NewFamily() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,90 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret the SHA1 key of the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCode(t *testing.T) {
+	// the last six digits of the eight digit codes in RFC 6238 appendix B
+	tests := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, tt := range tests {
+		code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		require.NoError(t, err)
+		assert.Equal(t, tt.code, code, "at %d", tt.unix)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+
+	step, ok, err := Validate(rfcSecret, "050471", now)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	// the previous step is still accepted, and reported as such
+	step, ok, err = Validate(rfcSecret, "050471", now.Add(Period))
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, Step(now), step)
+
+	_, ok, err = Validate(rfcSecret, "050471", now.Add(3*Period))
+	require.NoError(t, err)
+	assert.False(t, ok, "too old")
+
+	for _, code := range []string{"000000", "05047", "0504711", ""} {
+		_, ok, err = Validate(rfcSecret, code, now)
+		require.NoError(t, err)
+		assert.False(t, ok, code)
+	}
+
+	_, _, err = Validate("not base32!", "050471", now)
+	assert.Error(t, err)
+}
+
+func TestURI(t *testing.T) {
+	uri, err := url.Parse(URI("Thrive Track", "jane@example.com", "JBSWY3DPEHPK3PXP"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "otpauth", uri.Scheme)
+	assert.Equal(t, "totp", uri.Host)
+	assert.Equal(t, "/Thrive Track:jane@example.com", uri.Path)
+	assert.Equal(t, "JBSWY3DPEHPK3PXP", uri.Query().Get("secret"))
+	assert.Equal(t, "Thrive Track", uri.Query().Get("issuer"))
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes()
+	require.NoError(t, err)
+	require.Len(t, codes, RecoveryCodeCount)
+
+	seen := map[string]bool{}
+	for _, code := range codes {
+		assert.Regexp(t, `^[A-Z2-7]{5}-[A-Z2-7]{5}$`, code)
+		assert.False(t, seen[code])
+		seen[code] = true
+
+		typed := strings.ToLower(strings.ReplaceAll(code, "-", " "))
+		assert.Equal(t, code, NormalizeRecoveryCode(typed))
+	}
+}
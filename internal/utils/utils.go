@@ -1,11 +1,19 @@
 package utils
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nickemma/internal/logging"
+	"github.com/nickemma/internal/store"
+	"github.com/nickemma/internal/validator"
+	"math"
 	"net/http"
 	"strconv"
+	"time"
 )
 
 type Envelope map[string]interface{}
@@ -35,3 +43,89 @@ func ReadJSON(r *http.Request) (int64, error) {
 
 	return id, nil
 }
+
+// ReadIntQuery returns nil when the query parameter is not set
+func ReadIntQuery(r *http.Request, key string) (*int, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return nil, nil
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s must be an integer", key)
+	}
+	return &i, nil
+}
+
+// ReadTimeQuery accepts either a RFC3339 timestamp or a plain YYYY-MM-DD date,
+// returns nil when the query parameter is not set. the bool reports whether
+// only a date was given so callers can treat it as a whole day
+func ReadTimeQuery(r *http.Request, key string) (*time.Time, bool, error) {
+	value := r.URL.Query().Get(key)
+	if value == "" {
+		return nil, false, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, false, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s must be a RFC3339 timestamp or a YYYY-MM-DD date", key)
+	}
+	return &t, true, nil
+}
+
+// FailedValidation reports every invalid field at once, keyed by its JSON path
+func FailedValidation(w http.ResponseWriter, errors map[string]string) error {
+	return WriteJSON(w, http.StatusUnprocessableEntity, Envelope{"errors": errors})
+}
+
+// WriteError the response for an error coming out of a store, so every
+// handler answers the same way. unknown errors are logged and hidden behind a 500
+func WriteError(w http.ResponseWriter, r *http.Request, err error) error {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		return WriteJSON(w, http.StatusNotFound, Envelope{"error": "the requested resource could not be found"})
+	case errors.Is(err, store.ErrForbidden):
+		return WriteJSON(w, http.StatusForbidden, Envelope{"error": "you are not authorized to access this resource"})
+	case errors.Is(err, store.ErrDuplicate):
+		if fieldErrors := validator.ConstraintErrors(err); fieldErrors != nil {
+			return WriteJSON(w, http.StatusConflict, Envelope{"error": "the resource already exists", "errors": fieldErrors})
+		}
+		// a store error's message is written for clients, a raw unique
+		// violation would give away the table and constraint names
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) {
+			logging.FromContext(r.Context()).Warn("unmapped unique violation", "error", err)
+			return WriteJSON(w, http.StatusConflict, Envelope{"error": "the resource already exists"})
+		}
+		return WriteJSON(w, http.StatusConflict, Envelope{"error": err.Error()})
+	case errors.Is(err, store.ErrConflict):
+		return WriteJSON(w, http.StatusConflict, Envelope{"error": "the request conflicts with the current state of the resource, please try again"})
+	case errors.Is(err, context.DeadlineExceeded):
+		logging.FromContext(r.Context()).Error("request timed out", "error", err)
+		return WriteJSON(w, http.StatusServiceUnavailable, Envelope{"error": "the request took too long, please try again"})
+	}
+
+	if fieldErrors := validator.ConstraintErrors(err); fieldErrors != nil {
+		return FailedValidation(w, fieldErrors)
+	}
+
+	logging.FromContext(r.Context()).Error("internal server error", "error", err)
+	return WriteJSON(w, http.StatusInternalServerError, Envelope{"error": "internal server error"})
+}
+
+// RateLimitExceeded a 429 with Retry-After in whole seconds, at least one
+func RateLimitExceeded(w http.ResponseWriter, retryAfter time.Duration) error {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	return WriteJSON(w, http.StatusTooManyRequests, Envelope{"error": "too many requests, please try again later"})
+}
+
+// InvalidCredentials the same answer for an unknown user and a wrong password
+func InvalidCredentials(w http.ResponseWriter) error {
+	return WriteJSON(w, http.StatusUnauthorized, Envelope{"error": "invalid credentials"})
+}
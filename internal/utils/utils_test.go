@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/nickemma/internal/logging"
+	"github.com/nickemma/internal/store"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		body   string
+	}{
+		{name: "not found", err: store.ErrNotFound, status: http.StatusNotFound, body: "could not be found"},
+		{name: "forbidden", err: store.ErrWorkoutForbidden, status: http.StatusForbidden, body: "not authorized"},
+		{name: "duplicate", err: store.ErrDuplicateUsername, status: http.StatusConflict, body: "username already exists"},
+		{name: "unmapped duplicate", err: fmt.Errorf("%w: %w", store.ErrDuplicate, &pgconn.PgError{Code: "23505", ConstraintName: "recovery_codes_user_id_hash_key", Message: "duplicate key value violates unique constraint"}), status: http.StatusConflict, body: "the resource already exists"},
+		{name: "conflict", err: fmt.Errorf("%w: deadlock", store.ErrConflict), status: http.StatusConflict, body: "try again"},
+		{name: "check violation", err: &pgconn.PgError{Code: "23514", ConstraintName: "valid_workout_set_rpe"}, status: http.StatusUnprocessableEntity, body: "rpe must be between 1 and 10"},
+		{name: "timeout", err: fmt.Errorf("listing workouts: %w", context.DeadlineExceeded), status: http.StatusServiceUnavailable, body: "took too long"},
+		{name: "unknown", err: errors.New("connection reset"), status: http.StatusInternalServerError, body: "internal server error"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var logs bytes.Buffer
+			logger := slog.New(slog.NewTextHandler(&logs, nil)).With("request_id", "req-1")
+			r := httptest.NewRequest(http.MethodGet, "/workouts", nil)
+			r = r.WithContext(logging.NewContext(r.Context(), logger))
+			w := httptest.NewRecorder()
+			WriteError(w, r, tt.err)
+
+			assert.Equal(t, tt.status, w.Code)
+			assert.Contains(t, w.Body.String(), tt.body)
+			assert.NotContains(t, w.Body.String(), "connection reset")
+			assert.NotContains(t, w.Body.String(), "SQLSTATE")
+			if tt.status >= http.StatusInternalServerError {
+				assert.Contains(t, logs.String(), "request_id=req-1")
+			}
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package validator
+
+import (
+	"errors"
+	"regexp"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+var EmailRX = regexp.MustCompile(`^[a-zA-Z0-9._%+-]+@[a-zA-Z0-9.-]+\.[a-zA-Z]{2,}$`)
+
+// Validator collects every field error of a request instead of stopping at
+// the first one. keys are the JSON path of the field, like entries[1].reps
+type Validator struct {
+	Errors map[string]string
+}
+
+func New() *Validator {
+	return &Validator{Errors: map[string]string{}}
+}
+
+func (v *Validator) Valid() bool {
+	return len(v.Errors) == 0
+}
+
+// AddError the first error of a field wins
+func (v *Validator) AddError(key, message string) {
+	if _, exists := v.Errors[key]; !exists {
+		v.Errors[key] = message
+	}
+}
+
+// Check adds the error when ok is false
+func (v *Validator) Check(ok bool, key, message string) {
+	if !ok {
+		v.AddError(key, message)
+	}
+}
+
+func PermittedValue[T comparable](value T, permitted ...T) bool {
+	for _, p := range permitted {
+		if value == p {
+			return true
+		}
+	}
+	return false
+}
+
+func Matches(value string, rx *regexp.Regexp) bool {
+	return rx.MatchString(value)
+}
+
+type fieldError struct {
+	key     string
+	message string
+}
+
+// constraintErrors the field a named constraint guards, for violations the
+// request validation should have caught but the database caught first
+var constraintErrors = map[string]fieldError{
+	"users_username_key":       {"username", "a user with this username already exists"},
+	"users_email_key":          {"email", "a user with this email already exists"},
+	"valid_workout_entry":      {"entries", "an entry needs either reps or duration_seconds, not both"},
+	"valid_template_entry":     {"entries", "an entry needs either reps or duration_seconds, not both"},
+	"valid_workout_set":        {"entries", "a set needs either reps or duration_seconds, not both"},
+	"valid_template_set":       {"entries", "a set needs either reps or duration_seconds, not both"},
+	"valid_workout_set_type":   {"entries", "set_type must be warmup, working, drop or failure"},
+	"valid_template_set_type":  {"entries", "set_type must be warmup, working, drop or failure"},
+	"valid_workout_set_rpe":    {"entries", "rpe must be between 1 and 10"},
+	"valid_template_set_rpe":   {"entries", "rpe must be between 1 and 10"},
+	"idx_exercises_owner_name": {"name", "an exercise with this name already exists"},
+}
+
+// ConstraintErrors turns a postgres integrity violation into field errors,
+// nil when err is not one
+func ConstraintErrors(err error) map[string]string {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return nil
+	}
+
+	if known, ok := constraintErrors[pgErr.ConstraintName]; ok {
+		return map[string]string{known.key: known.message}
+	}
+
+	key := pgErr.ColumnName
+	if key == "" {
+		key = pgErr.ConstraintName
+	}
+
+	switch pgErr.Code {
+	case "23502": // not_null_violation
+		return map[string]string{key: "must be provided"}
+	case "23505": // unique_violation
+		return map[string]string{key: "already exists"}
+	case "23514": // check_violation
+		return map[string]string{key: "is invalid"}
+	case "22001": // string_data_right_truncation
+		return map[string]string{"request": "a value is too long"}
+	case "22003": // numeric_value_out_of_range
+		return map[string]string{"request": "a number is out of range"}
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package validator
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidator(t *testing.T) {
+	v := New()
+	assert.True(t, v.Valid())
+
+	v.Check(false, "title", "must be provided")
+	v.Check(false, "title", "must not be more than 255 bytes long")
+	v.Check(true, "duration_minutes", "must be greater than zero")
+	v.Check(PermittedValue("drop", "warmup", "working"), "entries[0].sets[1].set_type", "is invalid")
+
+	assert.False(t, v.Valid())
+	assert.Equal(t, map[string]string{
+		"title":                       "must be provided",
+		"entries[0].sets[1].set_type": "is invalid",
+	}, v.Errors)
+}
+
+func TestConstraintErrors(t *testing.T) {
+	check := fmt.Errorf("inserting entry: %w", &pgconn.PgError{Code: "23514", ConstraintName: "valid_workout_entry"})
+	assert.Equal(t, map[string]string{"entries": "an entry needs either reps or duration_seconds, not both"}, ConstraintErrors(check))
+
+	notNull := &pgconn.PgError{Code: "23502", ColumnName: "title"}
+	assert.Equal(t, map[string]string{"title": "must be provided"}, ConstraintErrors(notNull))
+
+	assert.Nil(t, ConstraintErrors(&pgconn.PgError{Code: "40001"}))
+	assert.Nil(t, ConstraintErrors(errors.New("connection refused")))
+}
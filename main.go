@@ -1,34 +1,116 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/nickemma/internal/app"
+	"github.com/nickemma/internal/config"
 	"github.com/nickemma/internal/routes"
+	"github.com/nickemma/internal/tracing"
 	"net/http"
-	"time"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func main() {
-	app, err := app.NewApplication()
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	traces, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter, cfg.Tracing.Endpoint, cfg.Tracing.SampleRatio, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	app, err := app.NewApplication(cfg)
 
 	if err != nil {
 		panic(err)
 	}
-	defer app.DB.Close()
 
-	app.Logger.Println("We are running our application!")
+	err = serve(app, cfg)
 
-	r := routes.SetUpRoute(app)
+	// the pool is closed only after every request has drained
+	closeErr := app.DB.Close()
+	flushCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if flushErr := traces.Shutdown(flushCtx); flushErr != nil {
+		app.Logger.Error("flushing traces", "error", flushErr)
+	}
+	if err != nil {
+		app.Logger.Error("server error", "error", err)
+		os.Exit(1)
+	}
+	if closeErr != nil {
+		app.Logger.Error("closing database", "error", closeErr)
+		os.Exit(1)
+	}
+	app.Logger.Info("server stopped")
+}
 
-	server := &http.Server{
-		Addr:         ":8080",
-		Handler:      r,
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 30 * time.Second,
+// serve blocks until SIGINT or SIGTERM, then stops accepting connections and
+// waits for in-flight requests up to the shutdown timeout
+func serve(app *app.Application, cfg *config.Config) error {
+	servers := []*http.Server{{
+		Addr:         cfg.Addr,
+		Handler:      routes.SetUpRoute(app),
+		IdleTimeout:  cfg.Server.IdleTimeout,
+		ReadTimeout:  cfg.Server.ReadTimeout,
+		WriteTimeout: cfg.Server.WriteTimeout,
+	}}
+	// the admin listener keeps /metrics off the public address
+	if app.Metrics != nil && cfg.Metrics.Addr != "" {
+		servers = append(servers, &http.Server{
+			Addr:         cfg.Metrics.Addr,
+			Handler:      routes.SetUpAdminRoute(app),
+			IdleTimeout:  cfg.Server.IdleTimeout,
+			ReadTimeout:  cfg.Server.ReadTimeout,
+			WriteTimeout: cfg.Server.WriteTimeout,
+		})
 	}
 
-	err = server.ListenAndServe()
-	if err != nil {
-		app.Logger.Fatal(err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, len(servers))
+	for _, server := range servers {
+		go func() {
+			app.Logger.Info("server listening", "addr", server.Addr)
+			serveErr <- server.ListenAndServe()
+		}()
+	}
+
+	var err error
+	select {
+	case err = <-serveErr:
+		// one listener failed, the others are stopped below
+	case <-ctx.Done():
+	}
+	// a second signal kills the process right away
+	stop()
+
+	app.Logger.Info("shutting down, waiting for in-flight requests", "timeout", cfg.Server.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+
+	for _, server := range servers {
+		shutdownErr := server.Shutdown(shutdownCtx)
+		if shutdownErr != nil && err == nil {
+			err = fmt.Errorf("shutting down %s: %w", server.Addr, shutdownErr)
+		}
+	}
+
+	if errors.Is(err, http.ErrServerClosed) {
+		return nil
 	}
+	return err
 }
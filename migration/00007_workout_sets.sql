@@ -0,0 +1,57 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS workout_sets (
+    id BIGSERIAL PRIMARY KEY,
+    entry_id BIGINT NOT NULL REFERENCES workout_entries(id) ON DELETE CASCADE,
+    set_number INTEGER NOT NULL,
+    set_type VARCHAR(20) NOT NULL DEFAULT 'working',
+    reps INTEGER,
+    duration_seconds INTEGER,
+    weight DECIMAL(5, 2),
+    rpe DECIMAL(3, 1),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT valid_workout_set CHECK(
+        (reps IS NOT NULL OR duration_seconds IS NOT NULL) AND
+        (reps IS NULL OR duration_seconds IS NULL)
+    ),
+    CONSTRAINT valid_workout_set_type CHECK(set_type IN ('warmup', 'working', 'drop', 'failure')),
+    CONSTRAINT valid_workout_set_rpe CHECK(rpe IS NULL OR (rpe >= 1 AND rpe <= 10))
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS idx_workout_sets_entry_id ON workout_sets(entry_id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS template_sets (
+    id BIGSERIAL PRIMARY KEY,
+    entry_id BIGINT NOT NULL REFERENCES template_entries(id) ON DELETE CASCADE,
+    set_number INTEGER NOT NULL,
+    set_type VARCHAR(20) NOT NULL DEFAULT 'working',
+    reps INTEGER,
+    duration_seconds INTEGER,
+    weight DECIMAL(5, 2),
+    rpe DECIMAL(3, 1),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    CONSTRAINT valid_template_set CHECK(
+        (reps IS NOT NULL OR duration_seconds IS NOT NULL) AND
+        (reps IS NULL OR duration_seconds IS NULL)
+    ),
+    CONSTRAINT valid_template_set_type CHECK(set_type IN ('warmup', 'working', 'drop', 'failure')),
+    CONSTRAINT valid_template_set_rpe CHECK(rpe IS NULL OR (rpe >= 1 AND rpe <= 10))
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS idx_template_sets_entry_id ON template_sets(entry_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE template_sets;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE workout_sets;
+-- +goose StatementEnd
@@ -0,0 +1,120 @@
+-- +goose Up
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS exercises (
+    id BIGSERIAL PRIMARY KEY,
+    -- NULL for the shared catalog, set for a user's custom exercises
+    user_id BIGINT REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(255) NOT NULL,
+    aliases TEXT[] NOT NULL DEFAULT '{}',
+    primary_muscles TEXT[] NOT NULL DEFAULT '{}',
+    secondary_muscles TEXT[] NOT NULL DEFAULT '{}',
+    equipment VARCHAR(50) NOT NULL DEFAULT 'none',
+    movement_pattern VARCHAR(50) NOT NULL DEFAULT 'other',
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE UNIQUE INDEX IF NOT EXISTS idx_exercises_owner_name ON exercises (COALESCE(user_id, 0), LOWER(name));
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE workout_entries
+    ADD COLUMN exercise_id BIGINT REFERENCES exercises(id) ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE template_entries
+    ADD COLUMN exercise_id BIGINT REFERENCES exercises(id) ON DELETE SET NULL;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO exercises (name, aliases, primary_muscles, secondary_muscles, equipment, movement_pattern) VALUES
+    ('Bench Press', '{"bb bench", "barbell bench press", "flat bench", "bench"}', '{"chest"}', '{"triceps", "shoulders"}', 'barbell', 'horizontal_push'),
+    ('Incline Bench Press', '{"incline bench", "incline barbell press"}', '{"chest"}', '{"shoulders", "triceps"}', 'barbell', 'horizontal_push'),
+    ('Dumbbell Bench Press', '{"db bench", "dumbbell press"}', '{"chest"}', '{"triceps", "shoulders"}', 'dumbbell', 'horizontal_push'),
+    ('Push-Up', '{"push up", "pushup", "push ups", "push-ups"}', '{"chest"}', '{"triceps", "shoulders", "core"}', 'bodyweight', 'horizontal_push'),
+    ('Dip', '{"dips", "parallel bar dip"}', '{"chest", "triceps"}', '{"shoulders"}', 'bodyweight', 'vertical_push'),
+    ('Overhead Press', '{"ohp", "military press", "standing press", "shoulder press"}', '{"shoulders"}', '{"triceps", "core"}', 'barbell', 'vertical_push'),
+    ('Dumbbell Shoulder Press', '{"db shoulder press", "seated dumbbell press"}', '{"shoulders"}', '{"triceps"}', 'dumbbell', 'vertical_push'),
+    ('Lateral Raise', '{"side raise", "lateral raises", "db lateral raise"}', '{"shoulders"}', '{}', 'dumbbell', 'isolation'),
+    ('Barbell Row', '{"bent over row", "bb row", "pendlay row"}', '{"back"}', '{"biceps", "forearms"}', 'barbell', 'horizontal_pull'),
+    ('Dumbbell Row', '{"db row", "one arm row", "single arm row"}', '{"back"}', '{"biceps"}', 'dumbbell', 'horizontal_pull'),
+    ('Seated Cable Row', '{"cable row", "seated row"}', '{"back"}', '{"biceps"}', 'cable', 'horizontal_pull'),
+    ('Pull-Up', '{"pull up", "pullup", "pull-ups", "pull ups"}', '{"back"}', '{"biceps", "forearms"}', 'bodyweight', 'vertical_pull'),
+    ('Chin-Up', '{"chin up", "chinup", "chin-ups"}', '{"back", "biceps"}', '{"forearms"}', 'bodyweight', 'vertical_pull'),
+    ('Lat Pulldown', '{"pulldown", "lat pull down"}', '{"back"}', '{"biceps"}', 'cable', 'vertical_pull'),
+    ('Face Pull', '{"face pulls"}', '{"shoulders"}', '{"back"}', 'cable', 'horizontal_pull'),
+    ('Barbell Curl', '{"bb curl", "curl", "biceps curl"}', '{"biceps"}', '{"forearms"}', 'barbell', 'isolation'),
+    ('Dumbbell Curl', '{"db curl", "hammer curl"}', '{"biceps"}', '{"forearms"}', 'dumbbell', 'isolation'),
+    ('Triceps Pushdown', '{"tricep pushdown", "rope pushdown", "cable pushdown"}', '{"triceps"}', '{}', 'cable', 'isolation'),
+    ('Skull Crusher', '{"skullcrusher", "lying triceps extension"}', '{"triceps"}', '{}', 'barbell', 'isolation'),
+    ('Back Squat', '{"squat", "squats", "bb squat", "barbell squat"}', '{"quads", "glutes"}', '{"hamstrings", "core"}', 'barbell', 'squat'),
+    ('Front Squat', '{"front squats"}', '{"quads"}', '{"glutes", "core"}', 'barbell', 'squat'),
+    ('Goblet Squat', '{"goblet squats"}', '{"quads", "glutes"}', '{"core"}', 'dumbbell', 'squat'),
+    ('Leg Press', '{"leg presses"}', '{"quads", "glutes"}', '{"hamstrings"}', 'machine', 'squat'),
+    ('Lunge', '{"lunges", "walking lunge", "walking lunges"}', '{"quads", "glutes"}', '{"hamstrings"}', 'bodyweight', 'lunge'),
+    ('Bulgarian Split Squat', '{"split squat", "bss"}', '{"quads", "glutes"}', '{"hamstrings"}', 'dumbbell', 'lunge'),
+    ('Deadlift', '{"deadlifts", "conventional deadlift", "dl"}', '{"hamstrings", "glutes", "back"}', '{"forearms", "core"}', 'barbell', 'hinge'),
+    ('Romanian Deadlift', '{"rdl", "romanian deadlifts", "stiff leg deadlift"}', '{"hamstrings", "glutes"}', '{"back"}', 'barbell', 'hinge'),
+    ('Hip Thrust', '{"hip thrusts", "barbell hip thrust"}', '{"glutes"}', '{"hamstrings"}', 'barbell', 'hinge'),
+    ('Kettlebell Swing', '{"kb swing", "swings"}', '{"glutes", "hamstrings"}', '{"core", "back"}', 'kettlebell', 'hinge'),
+    ('Leg Curl', '{"hamstring curl", "lying leg curl"}', '{"hamstrings"}', '{}', 'machine', 'isolation'),
+    ('Leg Extension', '{"leg extensions"}', '{"quads"}', '{}', 'machine', 'isolation'),
+    ('Calf Raise', '{"calf raises", "standing calf raise"}', '{"calves"}', '{}', 'machine', 'isolation'),
+    ('Plank', '{"planks", "front plank"}', '{"core"}', '{"shoulders"}', 'bodyweight', 'core'),
+    ('Hanging Leg Raise', '{"leg raise", "hanging knee raise"}', '{"core"}', '{"forearms"}', 'bodyweight', 'core'),
+    ('Crunch', '{"crunches", "sit up", "sit-ups"}', '{"core"}', '{}', 'bodyweight', 'core'),
+    ('Farmer''s Carry', '{"farmers walk", "farmer walk", "farmers carry"}', '{"forearms", "core"}', '{"back", "shoulders"}', 'dumbbell', 'carry'),
+    ('Running', '{"run", "jogging", "jog", "treadmill"}', '{"full_body"}', '{}', 'none', 'cardio'),
+    ('Walking', '{"walk"}', '{"full_body"}', '{}', 'none', 'cardio'),
+    ('Cycling', '{"bike", "stationary bike", "spin"}', '{"quads"}', '{"calves"}', 'machine', 'cardio'),
+    ('Rowing Machine', '{"rower", "erg", "indoor rowing"}', '{"full_body"}', '{"back"}', 'machine', 'cardio'),
+    ('Jump Rope', '{"skipping", "skipping rope"}', '{"calves"}', '{"shoulders"}', 'none', 'cardio'),
+    ('Burpee', '{"burpees"}', '{"full_body"}', '{}', 'bodyweight', 'cardio')
+ON CONFLICT DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- existing entries are linked the way resolveExercise links new ones: the
+-- name or an alias of a catalog exercise, renamed to the canonical name
+UPDATE workout_entries we
+SET exercise_id = e.id, exercise_name = e.name
+FROM exercises e
+WHERE e.id = (
+    SELECT c.id FROM exercises c
+    WHERE c.user_id IS NULL
+      AND (LOWER(c.name) = LOWER(TRIM(we.exercise_name))
+        OR EXISTS (SELECT 1 FROM unnest(c.aliases) a WHERE LOWER(a) = LOWER(TRIM(we.exercise_name))))
+    ORDER BY LOWER(c.name) = LOWER(TRIM(we.exercise_name)) DESC
+    LIMIT 1
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+UPDATE template_entries te
+SET exercise_id = e.id, exercise_name = e.name
+FROM exercises e
+WHERE e.id = (
+    SELECT c.id FROM exercises c
+    WHERE c.user_id IS NULL
+      AND (LOWER(c.name) = LOWER(TRIM(te.exercise_name))
+        OR EXISTS (SELECT 1 FROM unnest(c.aliases) a WHERE LOWER(a) = LOWER(TRIM(te.exercise_name))))
+    ORDER BY LOWER(c.name) = LOWER(TRIM(te.exercise_name)) DESC
+    LIMIT 1
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+ALTER TABLE template_entries DROP COLUMN exercise_id;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE workout_entries DROP COLUMN exercise_id;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE exercises;
+-- +goose StatementEnd
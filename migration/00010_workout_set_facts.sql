@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+-- one row per performed set, entries logged before workout_sets existed are
+-- expanded from their aggregate sets/reps/weight columns
+CREATE OR REPLACE VIEW workout_set_facts AS
+SELECT w.user_id, w.id AS workout_id, w.created_at AS workout_created_at,
+       e.id AS entry_id, e.exercise_id, e.exercise_name,
+       s.set_number, s.set_type, s.reps, s.duration_seconds, s.weight, s.rpe
+FROM workouts w
+JOIN workout_entries e ON e.workout_id = w.id
+JOIN workout_sets s ON s.entry_id = e.id
+UNION ALL
+SELECT w.user_id, w.id, w.created_at,
+       e.id, e.exercise_id, e.exercise_name,
+       n.set_number, 'working', e.reps, e.duration_seconds, e.weight, NULL
+FROM workouts w
+JOIN workout_entries e ON e.workout_id = w.id
+CROSS JOIN LATERAL generate_series(1, e.sets) AS n(set_number)
+WHERE NOT EXISTS (SELECT 1 FROM workout_sets s WHERE s.entry_id = e.id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP VIEW workout_set_facts;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE tokens
+    ADD COLUMN id BIGSERIAL UNIQUE,
+    ADD COLUMN created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    ADD COLUMN last_used_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN user_agent TEXT NOT NULL DEFAULT '';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS idx_tokens_user_scope ON tokens(user_id, scope);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_tokens_user_scope;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE tokens
+    DROP COLUMN user_agent,
+    DROP COLUMN last_used_at,
+    DROP COLUMN created_at,
+    DROP COLUMN id;
+-- +goose StatementEnd
@@ -0,0 +1,21 @@
+-- +goose Up
+-- +goose StatementBegin
+ALTER TABLE tokens
+    ADD COLUMN family_id TEXT,
+    ADD COLUMN rotated_at TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS idx_tokens_family_id ON tokens(family_id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP INDEX idx_tokens_family_id;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE tokens
+    DROP COLUMN rotated_at,
+    DROP COLUMN family_id;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+-- totp_secret is set by the setup, two_factor_enabled only once a code from
+-- it was verified. totp_last_step is the time step of the last code used,
+-- a code is never accepted twice
+ALTER TABLE users
+  ADD COLUMN totp_secret TEXT,
+  ADD COLUMN two_factor_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+  ADD COLUMN totp_last_step BIGINT;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  id BIGSERIAL PRIMARY KEY,
+  user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  hash BYTEA NOT NULL,
+  used_at TIMESTAMP WITH TIME ZONE,
+  UNIQUE (user_id, hash)
+);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DROP TABLE IF EXISTS recovery_codes;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+ALTER TABLE users DROP COLUMN totp_secret, DROP COLUMN two_factor_enabled, DROP COLUMN totp_last_step;
+-- +goose StatementEnd
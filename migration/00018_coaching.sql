@@ -0,0 +1,73 @@
+-- +goose Up
+-- +goose StatementBegin
+-- a row is an invitation until the athlete accepts it, invite_hash is the
+-- hash of the coach-invite token that was mailed to them
+CREATE TABLE IF NOT EXISTS coach_athletes (
+  coach_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  athlete_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  invite_hash BYTEA,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  accepted_at TIMESTAMP WITH TIME ZONE,
+  PRIMARY KEY (coach_id, athlete_id),
+  CHECK (coach_id <> athlete_id)
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS idx_coach_athletes_athlete ON coach_athletes (athlete_id);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE TABLE IF NOT EXISTS entry_comments (
+  id BIGSERIAL PRIMARY KEY,
+  workout_entry_id BIGINT NOT NULL REFERENCES workout_entries(id) ON DELETE CASCADE,
+  author_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  body TEXT NOT NULL,
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS idx_entry_comments_entry ON entry_comments (workout_entry_id, created_at);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+-- the plan itself is a template owned by the athlete, so it is started like any other
+CREATE TABLE IF NOT EXISTS planned_workouts (
+  id BIGSERIAL PRIMARY KEY,
+  athlete_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+  coach_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+  template_id BIGINT NOT NULL REFERENCES workout_templates(id) ON DELETE CASCADE,
+  scheduled_for DATE NOT NULL,
+  notes TEXT NOT NULL DEFAULT '',
+  created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE INDEX IF NOT EXISTS idx_planned_workouts_athlete_date ON planned_workouts (athlete_id, scheduled_for);
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+INSERT INTO role_permissions (role, permission) VALUES
+  ('coach', 'athletes:coach'),
+  ('admin', 'athletes:coach')
+ON CONFLICT DO NOTHING;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+DELETE FROM role_permissions WHERE permission = 'athletes:coach';
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS planned_workouts;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS entry_comments;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+DROP TABLE IF EXISTS coach_athletes;
+-- +goose StatementEnd